@@ -0,0 +1,72 @@
+package httpreaderat
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// NewRequestBasicAuth creates a GET http.Request for url which uses HTTP
+// Basic Authentication with the given credentials. The result can be used
+// as the prototype request for New.
+func NewRequestBasicAuth(url, username, password string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(username, password)
+	return req, nil
+}
+
+// NewRequestBearerToken creates a GET http.Request for url which uses
+// the given bearer token for authentication. The result can be used as the
+// prototype request for New.
+func NewRequestBearerToken(url, token string) (*http.Request, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+const redacted = "[REDACTED]"
+
+// sensitiveHeaders are the headers which are redacted from errors.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// redactHeader returns a copy of h with credentials redacted.
+func redactHeader(h http.Header) http.Header {
+	h2 := cloneHeader(h)
+	for _, k := range sensitiveHeaders {
+		if _, ok := h2[k]; ok {
+			h2[k] = []string{redacted}
+		}
+	}
+	return h2
+}
+
+// redactURL returns u as a string with the password redacted.
+func redactURL(u *url.URL) string {
+	if u == nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// redactError redacts the password from the URL of a *url.Error
+// returned by http.Client.
+func redactError(err error) error {
+	if ue, ok := err.(*url.Error); ok {
+		if u, perr := url.Parse(ue.URL); perr == nil {
+			ue2 := *ue
+			ue2.URL = redactURL(u)
+			return &ue2
+		}
+	}
+	return err
+}
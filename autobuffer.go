@@ -0,0 +1,54 @@
+package httpreaderat
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// maybeAutoBuffer buffers the whole remote file to the Store if the amount
+// of data fetched so far exceeds the threshold set with
+// WithAutoBufferThreshold. It is attempted only once. The download is made
+// with the context ctx of the ReadAt call crossing the threshold.
+func (ra *HTTPReaderAt) maybeAutoBuffer(ctx context.Context) {
+	if ra.autoBufferFraction <= 0 || ra.usebs ||
+		atomic.LoadInt32(&ra.autoBuffered) == 1 {
+		return
+	}
+	size := ra.Size()
+	fetched := atomic.LoadInt64(&ra.counters.bytesFetched)
+	if size <= 0 || float64(fetched) <= ra.autoBufferFraction*float64(size) {
+		return
+	}
+
+	ra.autoBufferMu.Lock()
+	defer ra.autoBufferMu.Unlock()
+
+	if ra.autoBufferTried || (ra.bs == nil && ra.bsf == nil) {
+		return
+	}
+	ra.autoBufferTried = true
+
+	bs, own := ra.bs, false
+	if bs == nil {
+		bs, own = ra.bsf(), true
+	}
+	body, err := ra.openRange(ctx, 0, size-1)
+	if err == nil {
+		var n int64
+		n, err = bs.ReadFrom(body)
+		body.Close()
+		atomic.AddInt64(&ra.counters.bytesFetched, n)
+		if err == nil && n != size {
+			err = ErrValidationFailed
+		}
+	}
+	if err != nil {
+		// keep on making Range Requests
+		if own {
+			bs.Close()
+		}
+		return
+	}
+	ra.bs, ra.ownbs = bs, own
+	atomic.StoreInt32(&ra.autoBuffered, 1)
+}
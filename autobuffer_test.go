@@ -0,0 +1,122 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAutoBuffer(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, NewStoreMemory(), WithAutoBufferThreshold(0.5))
+
+	readAndCheck(t, ra, data, 0, 400)
+	if ra.autoBuffered != 0 {
+		t.Fatal("buffered below the threshold")
+	}
+	// crossing the threshold downloads the whole file
+	readAndCheck(t, ra, data, 400, 200)
+	if ra.autoBuffered != 1 {
+		t.Fatal("not buffered above the threshold")
+	}
+	requests := ts.Requests()
+	readAndCheck(t, ra, data, 600, 400)
+	if ts.Requests() != requests {
+		t.Errorf("requests after buffering = %d; want 0", ts.Requests()-requests)
+	}
+}
+
+func TestAutoBufferReset(t *testing.T) {
+	data := makeTestData(1000)
+	data2 := bytes.Repeat([]byte("x"), 1000)
+	ts := newTestServer(t, data, nil)
+	ts2 := newTestServer(t, data2, nil)
+	tests := []struct {
+		name string
+		bs   Store
+		opts []Option
+	}{
+		{"store", NewStoreMemory(), nil},
+		{"auto store", nil, []Option{WithAutoStore(true)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithAutoBufferThreshold(0.5)}, tt.opts...)
+			ra := newTestReaderAt(t, ts.URL, tt.bs, opts...)
+			readAndCheck(t, ra, data, 0, 600)
+			if ra.autoBuffered != 1 {
+				t.Fatal("not buffered above the threshold")
+			}
+
+			// the new file is read with Range Requests, not from the
+			// Store holding the previous file
+			req, _ := http.NewRequest("GET", ts2.URL, nil)
+			if err := ra.Reset(req); err != nil {
+				t.Fatal(err)
+			}
+			readAndCheck(t, ra, data2, 100, 100)
+
+			// and it can be buffered again
+			readAndCheck(t, ra, data2, 200, 600)
+			if ra.autoBuffered != 1 {
+				t.Fatal("not buffered again after Reset")
+			}
+			if err := ra.Close(); err != nil {
+				t.Fatal(err)
+			}
+			readAndCheck(t, ra, data2, 300, 100)
+		})
+	}
+}
+
+func TestAutoBufferContext(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data}
+	release := make(chan struct{})
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-999" {
+			// the whole file download does not complete
+			select {
+			case <-r.Context().Done():
+			case <-release:
+			}
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	t.Cleanup(func() { close(release) })
+	for _, deadline := range []bool{false, true} {
+		var opts []Option
+		ctx := context.Background()
+		if deadline {
+			opts = append(opts, WithOperationDeadline(50*time.Millisecond))
+		} else {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+		}
+		ra := newTestReaderAt(t, ts.URL, NewStoreMemory(),
+			append(opts, WithAutoBufferThreshold(0.5))...)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			p := make([]byte, 600)
+			if n, err := ra.ReadAtContext(ctx, p, 0); n != len(p) || err != nil {
+				t.Errorf("deadline %v: ReadAtContext = %d, %v", deadline, n, err)
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("deadline %v: ReadAtContext blocked by the whole file download", deadline)
+		}
+		if ra.autoBuffered != 0 {
+			t.Errorf("deadline %v: buffered although the download failed", deadline)
+		}
+		// Range Requests continue to be used
+		readAndCheck(t, ra, data, 600, 100)
+	}
+}
@@ -0,0 +1,176 @@
+package httpreaderat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// azureVersion is the Azure Storage REST API version used for the
+// requests made by NewAzureBlobReaderAt.
+const azureVersion = "2020-10-02"
+
+// AzureCredential is the interface to a credential used for authorizing
+// the requests made by NewAzureBlobReaderAt. Authorize is called for each
+// request (including retries and redirects) just before it is sent; it
+// may modify the request, which is a copy owned by the caller.
+type AzureCredential interface {
+	Authorize(req *http.Request) error
+}
+
+// AzureSharedKeyCredential authorizes requests with the Shared Key
+// authorization scheme of Azure Storage using the storage account name
+// and the base64 encoded account key.
+type AzureSharedKeyCredential struct {
+	AccountName string
+	AccountKey  string
+}
+
+var _ AzureCredential = AzureSharedKeyCredential{}
+
+// Authorize sets the "x-ms-date" and "Authorization" headers of req.
+func (c AzureSharedKeyCredential) Authorize(req *http.Request) error {
+	key, err := base64.StdEncoding.DecodeString(c.AccountKey)
+	if err != nil {
+		return errors.Wrap(err, "invalid azure account key")
+	}
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(azureStringToSign(req, c.AccountName)))
+	sig := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+c.AccountName+":"+sig)
+	return nil
+}
+
+// azureStringToSign returns the string to sign of the Shared Key
+// authorization scheme for req.
+func azureStringToSign(req *http.Request, account string) string {
+	h := req.Header
+	var b strings.Builder
+	b.WriteString(req.Method + "\n")
+	for _, k := range []string{
+		"Content-Encoding", "Content-Language", "Content-Length",
+		"Content-MD5", "Content-Type", "Date", "If-Modified-Since",
+		"If-Match", "If-None-Match", "If-Unmodified-Since", "Range",
+	} {
+		v := h.Get(k)
+		if k == "Content-Length" && v == "0" {
+			v = ""
+		}
+		b.WriteString(v + "\n")
+	}
+
+	// canonicalized headers
+	var names []string
+	for k := range h {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			names = append(names, lk)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		v := strings.Join(strings.Fields(h.Get(k)), " ")
+		b.WriteString(k + ":" + v + "\n")
+	}
+
+	// canonicalized resource
+	b.WriteString("/" + account + req.URL.EscapedPath())
+	query := make(map[string][]string)
+	var params []string
+	for k, vv := range req.URL.Query() {
+		lk := strings.ToLower(k)
+		if _, ok := query[lk]; !ok {
+			params = append(params, lk)
+		}
+		query[lk] = append(query[lk], vv...)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		vv := query[k]
+		sort.Strings(vv)
+		b.WriteString("\n" + k + ":" + strings.Join(vv, ","))
+	}
+	return b.String()
+}
+
+// AzureSASCredential authorizes requests with a shared access signature
+// token, the query string of a SAS URL (with or without the leading "?").
+type AzureSASCredential string
+
+var _ AzureCredential = AzureSASCredential("")
+
+// Authorize adds the parameters of the SAS token to the URL of req.
+func (c AzureSASCredential) Authorize(req *http.Request) error {
+	sas, err := url.ParseQuery(strings.TrimPrefix(string(c), "?"))
+	if err != nil {
+		return errors.Wrap(err, "invalid azure sas token")
+	}
+	u := *req.URL
+	q := u.Query()
+	for k, vv := range sas {
+		q[k] = vv
+	}
+	u.RawQuery = q.Encode()
+	req.URL = &u
+	return nil
+}
+
+// azureTransport is http.RoundTripper which authorizes each request with
+// an AzureCredential.
+type azureTransport struct {
+	base http.RoundTripper
+	cred AzureCredential
+}
+
+func (t *azureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	if err := t.cred.Authorize(req); err != nil {
+		return nil, err
+	}
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// NewAzureBlobReaderAt creates a new HTTPReaderAt for the blob called blob
+// in container of the Azure Blob Storage account at accountURL (for
+// example "https://myaccount.blob.core.windows.net"). The ranges are
+// requested with the "x-ms-range" header and each request is authorized
+// with cred, for example an AzureSharedKeyCredential. cred may be nil for
+// public containers or if accountURL already contains a SAS token. The
+// requests are made with http.DefaultClient, or with the transport given
+// with WithTransport. The Options are passed to New.
+func NewAzureBlobReaderAt(ctx context.Context, accountURL, container, blob string, cred AzureCredential, opts ...Option) (*HTTPReaderAt, error) {
+	u, err := url.Parse(strings.TrimSuffix(accountURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path += "/" + container + "/" + blob
+	u.RawPath = ""
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("x-ms-version", azureVersion)
+
+	// Azure Blob Storage prefers "x-ms-range" over "Range"
+	opts = append([]Option{WithRangeHeaderName("x-ms-range")}, opts...)
+	if cred != nil {
+		opts = append(opts, func(ra *HTTPReaderAt) {
+			c := *ra.client
+			c.Transport = &azureTransport{base: c.Transport, cred: cred}
+			ra.client = &c
+		})
+	}
+	return NewContext(ctx, nil, req, nil, opts...)
+}
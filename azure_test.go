@@ -0,0 +1,74 @@
+package httpreaderat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAzureBlobReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	key := base64.StdEncoding.EncodeToString([]byte("secret account key"))
+	h := &rangeHandler{data: data, header: "x-ms-range"}
+	var failures int32
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		mac := hmac.New(sha256.New, []byte("secret account key"))
+		mac.Write([]byte(azureStringToSign(r, "account")))
+		want := "SharedKey account:" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+		if r.URL.Path != "/container/dir/blob" || r.Header.Get("x-ms-date") == "" ||
+			r.Header.Get("x-ms-version") != azureVersion ||
+			r.Header.Get("x-ms-range") == "" || r.Header.Get("Range") != "" ||
+			r.Header.Get("Authorization") != want {
+			atomic.AddInt32(&failures, 1)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	cred := AzureSharedKeyCredential{AccountName: "account", AccountKey: key}
+	ra, err := NewAzureBlobReaderAt(context.Background(), ts.URL+"/", "container", "dir/blob", cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+	if ra.Size() != int64(len(data)) {
+		t.Errorf("Size() = %d; want %d", ra.Size(), len(data))
+	}
+	readAndCheck(t, ra, data, 100, 200)
+	readAndCheck(t, ra, data, 900, 100)
+	if n := atomic.LoadInt32(&failures); n != 0 {
+		t.Errorf("%d requests were not authorized correctly", n)
+	}
+
+	cred.AccountKey = "not base64!"
+	if _, err := NewAzureBlobReaderAt(context.Background(), ts.URL, "container", "dir/blob", cred); err == nil {
+		t.Error("NewAzureBlobReaderAt with an invalid key succeeded")
+	}
+}
+
+func TestAzureSASCredential(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data, header: "x-ms-range"}
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("sv") != azureVersion || q.Get("sig") != "abc=" || q.Get("x") != "1" ||
+			r.Header.Get("Authorization") != "" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+
+	cred := AzureSASCredential("?sv=" + azureVersion + "&sig=abc%3D")
+	ra, err := NewAzureBlobReaderAt(context.Background(), ts.URL+"?x=1", "container", "blob", cred)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+	readAndCheck(t, ra, data, 0, 500)
+}
@@ -0,0 +1,189 @@
+package httpreaderat
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// readBatcher collects concurrent ReadAt calls arriving within a time
+// window and serves clusters of nearby reads with single spanning range
+// requests.
+type readBatcher struct {
+	ra     *HTTPReaderAt
+	window time.Duration
+	maxGap int64
+
+	mu      sync.Mutex
+	pending []*batchRead
+	timer   *time.Timer
+}
+
+type batchRead struct {
+	p    []byte
+	off  int64
+	n    int
+	err  error
+	done chan struct{}
+
+	mu       sync.Mutex // guards canceled and cluster, held while done is closed
+	canceled bool       // the caller has given up, p must not be written to
+	cluster  *batchCluster
+}
+
+// batchCluster is a group of reads served by a single request. The
+// request is canceled once all the reads of the cluster are canceled.
+type batchCluster struct {
+	mu     sync.Mutex
+	live   int // number of reads not canceled
+	cancel context.CancelFunc
+}
+
+func (c *batchCluster) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.live--
+	if c.live == 0 {
+		c.cancel()
+	}
+}
+
+// readAt queues the read to be batched and waits for it to complete or for
+// ctx to be canceled.
+func (b *readBatcher) readAt(ctx context.Context, p []byte, off int64) (n int, err error) {
+	if _, err = rangeEnd(off, int64(len(p))); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	r := &batchRead{p: p, off: off, done: make(chan struct{})}
+
+	b.mu.Lock()
+	b.pending = append(b.pending, r)
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.window, b.flush)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-r.done:
+		return r.n, r.err
+	case <-ctx.Done():
+	}
+	b.mu.Lock()
+	for i, q := range b.pending {
+		if q == r {
+			b.pending = append(b.pending[:i:i], b.pending[i+1:]...)
+			break
+		}
+	}
+	b.mu.Unlock()
+
+	r.mu.Lock()
+	select {
+	case <-r.done:
+		r.mu.Unlock()
+		return r.n, r.err
+	default:
+	}
+	r.canceled = true
+	c := r.cluster
+	r.mu.Unlock()
+	if c != nil {
+		c.release()
+	}
+	return 0, ctx.Err()
+}
+
+// flush issues the pending reads immediately.
+func (b *readBatcher) flush() {
+	b.mu.Lock()
+	reads := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+
+	if len(reads) == 0 {
+		return
+	}
+	sort.Slice(reads, func(i, j int) bool {
+		return reads[i].off < reads[j].off
+	})
+	var wg sync.WaitGroup
+	first := 0
+	start, end := reads[0].off, reads[0].off+int64(len(reads[0].p))
+	for i := 1; i <= len(reads); i++ {
+		if i < len(reads) && reads[i].off-end <= b.maxGap &&
+			reads[i].off+int64(len(reads[i].p))-start <= maxInt {
+			if e := reads[i].off + int64(len(reads[i].p)); e > end {
+				end = e
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(cluster []*batchRead, start, end int64) {
+			defer wg.Done()
+			b.issue(cluster, start, end)
+		}(reads[first:i], start, end)
+
+		if i < len(reads) {
+			first = i
+			start, end = reads[i].off, reads[i].off+int64(len(reads[i].p))
+		}
+	}
+	wg.Wait()
+}
+
+// issue makes a single range request covering [start, end) and scatters
+// the results to the clustered reads which have not been canceled. The
+// request is canceled if all the reads are canceled.
+func (b *readBatcher) issue(cluster []*batchRead, start, end int64) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &batchCluster{cancel: cancel}
+	c.mu.Lock()
+	for _, r := range cluster {
+		r.mu.Lock()
+		if !r.canceled {
+			r.cluster = c
+			c.live++
+		}
+		r.mu.Unlock()
+	}
+	live := c.live
+	c.mu.Unlock()
+
+	var n int
+	var err error
+	var buf []byte
+	if live > 0 {
+		buf = b.ra.getBuf(int(end - start))
+		defer b.ra.putBuf(buf)
+		n, err = b.ra.readAt(ctx, buf, start, false, nil)
+	}
+	if err == nil {
+		err = io.EOF // only used if some read is not fully satisfied
+	}
+	for _, r := range cluster {
+		r.mu.Lock()
+		if !r.canceled {
+			rel := r.off - start
+			if rel < int64(n) {
+				r.n = copy(r.p, buf[rel:n])
+			}
+			if r.n < len(r.p) {
+				r.err = err
+			}
+		}
+		close(r.done)
+		r.mu.Unlock()
+	}
+}
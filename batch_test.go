@@ -0,0 +1,52 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadBatching(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil, WithReadBatching(time.Hour, 100))
+	probed := ts.Requests()
+
+	// the first two reads are within maxGap of each other
+	offs := []int64{0, 150, 800}
+	bufs := make([][]byte, len(offs))
+	var wg sync.WaitGroup
+	for i, off := range offs {
+		bufs[i] = make([]byte, 100)
+		wg.Add(1)
+		go func(p []byte, off int64) {
+			defer wg.Done()
+			if n, err := ra.ReadAt(p, off); n != len(p) || err != nil {
+				t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+			}
+		}(bufs[i], off)
+	}
+	// wait for the reads to be queued, then issue them without waiting
+	// for the window
+	for {
+		ra.batcher.mu.Lock()
+		n := len(ra.batcher.pending)
+		ra.batcher.mu.Unlock()
+		if n == len(offs) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ra.Flush()
+	wg.Wait()
+
+	for i, off := range offs {
+		if !bytes.Equal(bufs[i], data[off:off+100]) {
+			t.Errorf("ReadAt(%d) returned wrong data", off)
+		}
+	}
+	if n := ts.Requests() - probed; n != 2 {
+		t.Errorf("requests = %d; want 2", n)
+	}
+}
@@ -0,0 +1,27 @@
+package httpreaderat
+
+// BufferPool is the interface to a pool of scratch buffers given with
+// WithBufferPool. Get returns a buffer of length size, which may have been
+// used before. Put returns a buffer obtained with Get to the pool; the
+// buffer is not used by HTTPReaderAt after Put. Both must be safe for
+// concurrent use.
+type BufferPool interface {
+	Get(size int) []byte
+	Put(buf []byte)
+}
+
+// getBuf returns a scratch buffer of length size from the BufferPool
+// given with WithBufferPool or a newly allocated one.
+func (ra *HTTPReaderAt) getBuf(size int) []byte {
+	if ra.bufPool == nil {
+		return make([]byte, size)
+	}
+	return ra.bufPool.Get(size)
+}
+
+// putBuf returns a scratch buffer obtained with getBuf.
+func (ra *HTTPReaderAt) putBuf(buf []byte) {
+	if ra.bufPool != nil {
+		ra.bufPool.Put(buf)
+	}
+}
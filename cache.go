@@ -0,0 +1,271 @@
+package httpreaderat
+
+import (
+	"container/list"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoCache error is returned by Prefetch if the block cache is not
+// enabled with WithBlockCache.
+var ErrNoCache = errors.New("block cache not enabled")
+
+// blockCache is a LRU cache of fixed size blocks of the remote file.
+// It is safe for concurrent use.
+type blockCache struct {
+	blockSize int64
+	maxBlocks int
+
+	mu     sync.Mutex
+	blocks map[int64]*list.Element
+	lru    *list.List
+}
+
+type cacheBlock struct {
+	idx  int64
+	data []byte
+}
+
+func newBlockCache(blockSize int64, maxBlocks int) *blockCache {
+	return &blockCache{
+		blockSize: blockSize,
+		maxBlocks: maxBlocks,
+		blocks:    make(map[int64]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+func (c *blockCache) get(idx int64) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.blocks[idx]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	return e.Value.(*cacheBlock).data
+}
+
+func (c *blockCache) put(idx int64, data []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blocks[idx]; ok {
+		e.Value.(*cacheBlock).data = data
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.blocks[idx] = c.lru.PushFront(&cacheBlock{idx: idx, data: data})
+	for c.maxBlocks > 0 && c.lru.Len() > c.maxBlocks {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.blocks, e.Value.(*cacheBlock).idx)
+	}
+}
+
+func (c *blockCache) remove(idx int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.blocks[idx]; ok {
+		c.lru.Remove(e)
+		delete(c.blocks, idx)
+	}
+}
+
+// fetchBlocks retrieves blocks first...last (inclusive) from the server
+// with a single range request and stores them in the cache. The fetched
+// blocks are also returned because they may get evicted from the cache
+// before the caller gets to use them. The last block may be shorter than
+// blockSize at the end of the file. If an error other than io.EOF occurs,
+// the complete blocks received before the error are returned along with
+// the error.
+func (ra *HTTPReaderAt) fetchBlocks(ctx context.Context, first, last int64) (blks [][]byte, err error) {
+	c := ra.cache
+	if last-first+1 > maxInt/c.blockSize {
+		return nil, errRangeOverflow
+	}
+	buf := make([]byte, (last-first+1)*c.blockSize)
+	n, err := ra.readAt(ctx, buf, first*c.blockSize, false, nil)
+	if err == io.EOF {
+		err = nil
+	} else if err != nil {
+		// do not cache a partially received block
+		n -= n % int(c.blockSize)
+	}
+	for i := first; i <= last; i++ {
+		start := (i - first) * c.blockSize
+		if start >= int64(n) {
+			break
+		}
+		stop := start + c.blockSize
+		if stop > int64(n) {
+			stop = int64(n)
+		}
+		blk := buf[start:stop:stop]
+		c.put(i, blk)
+		blks = append(blks, blk)
+	}
+	return blks, err
+}
+
+// readAtCached serves ReadAt from the block cache, fetching any missing
+// blocks from the server with a single range request.
+func (ra *HTTPReaderAt) readAtCached(ctx context.Context, p []byte, off int64) (n int, err error) {
+	end, err := rangeEnd(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	c := ra.cache
+	size := ra.Size()
+	if size != -1 && end > size && ra.refreshSizeOnEOF {
+		size, err = ra.refreshSize(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if size != -1 && end > size {
+		end = size
+		if end <= off {
+			return 0, io.EOF
+		}
+	}
+	first, last := off/c.blockSize, (end-1)/c.blockSize
+
+	blks := make([][]byte, last-first+1)
+	missFirst, missLast := int64(-1), int64(-1)
+	for i := first; i <= last; i++ {
+		blks[i-first] = c.get(i)
+		if blks[i-first] == nil {
+			if missFirst == -1 {
+				missFirst = i
+			}
+			missLast = i
+		}
+	}
+	var fetchErr error
+	if missFirst == -1 {
+		atomic.AddInt64(&ra.counters.cacheHits, 1)
+	} else {
+		var fetched [][]byte
+		fetched, fetchErr = ra.fetchBlocks(ctx, missFirst, missLast)
+		for i := missFirst; i <= missLast; i++ {
+			if i-missFirst < int64(len(fetched)) {
+				blks[i-first] = fetched[i-missFirst]
+			} else {
+				blks[i-first] = nil
+			}
+		}
+	}
+	for i, blk := range blks {
+		blkStart := (first + int64(i)) * c.blockSize
+		from := off - blkStart
+		if from < 0 {
+			from = 0
+		}
+		to := end - blkStart
+		if to > int64(len(blk)) {
+			to = int64(len(blk))
+		}
+		if from >= to {
+			break
+		}
+		m := copy(p[n:], blk[from:to])
+		if idx := first + int64(i); missFirst == -1 || idx < missFirst || idx > missLast {
+			atomic.AddInt64(&ra.counters.bytesServedFromCache, int64(m))
+		}
+		n += m
+		if int64(len(blk)) < c.blockSize {
+			break
+		}
+	}
+	if n < len(p) {
+		if fetchErr != nil {
+			return n, fetchErr
+		}
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Prefetch retrieves the given ranges of the remote file into the block
+// cache so that subsequent ReadAt calls can be served locally. Overlapping
+// ranges and blocks which are already cached are retrieved only once. The
+// requests are made concurrently, limited by WithMaxConcurrency if set.
+// The block cache must be enabled with WithBlockCache, otherwise
+// ErrNoCache is returned.
+func (ra *HTTPReaderAt) Prefetch(ranges []Range) error {
+	if ra.cache == nil {
+		return ErrNoCache
+	}
+	if ra.usebs {
+		return nil
+	}
+	type span struct{ first, last int64 }
+
+	// convert the ranges to block spans and merge overlapping ones
+	var spans []span
+	for _, r := range ranges {
+		if r.Len <= 0 {
+			continue
+		}
+		end, err := rangeEnd(r.Off, r.Len)
+		if err != nil {
+			return err
+		}
+		if size := ra.Size(); size != -1 && end > size {
+			end = size
+		}
+		if end <= r.Off {
+			continue
+		}
+		spans = append(spans, span{
+			r.Off / ra.cache.blockSize, (end - 1) / ra.cache.blockSize})
+	}
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].first < spans[j].first
+	})
+	var missing []span
+	next := int64(-1)
+	for _, sp := range spans {
+		if sp.first < next {
+			sp.first = next
+		}
+		for i := sp.first; i <= sp.last; i++ {
+			if ra.cache.get(i) != nil {
+				continue
+			}
+			if l := len(missing); l > 0 && missing[l-1].last == i-1 {
+				missing[l-1].last = i
+			} else {
+				missing = append(missing, span{i, i})
+			}
+		}
+		if sp.last+1 > next {
+			next = sp.last + 1
+		}
+	}
+
+	errs := make(chan error, len(missing))
+	for _, sp := range missing {
+		go func(sp span) {
+			_, err := ra.fetchBlocks(context.Background(), sp.first, sp.last)
+			errs <- err
+		}(sp)
+	}
+	var err error
+	for range missing {
+		if err2 := <-errs; err2 != nil && err == nil {
+			err = err2
+		}
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package httpreaderat
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPrefetch(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil, WithBlockCache(100, 0))
+	probed := ts.Requests()
+
+	// blocks 0-1 (overlapping ranges) and 7-8
+	ranges := []Range{{50, 100}, {120, 50}, {750, 100}}
+	if err := ra.Prefetch(ranges); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.Requests() - probed; n != 2 {
+		t.Errorf("Prefetch made %d requests; want 2", n)
+	}
+	readAndCheck(t, ra, data, 0, 200)
+	readAndCheck(t, ra, data, 700, 200)
+	if n := ts.Requests() - probed; n != 2 {
+		t.Errorf("ReadAt of prefetched data made %d requests; want 0", n-2)
+	}
+
+	// already cached blocks are not retrieved again
+	if err := ra.Prefetch(append(ranges, Range{150, 100})); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.Requests() - probed; n != 3 {
+		t.Errorf("Prefetch made %d requests; want 1", n-2)
+	}
+	readAndCheck(t, ra, data, 200, 50)
+	if n := ts.Requests() - probed; n != 3 {
+		t.Errorf("ReadAt of prefetched data made %d requests; want 0", n-3)
+	}
+}
+
+func TestPrefetchNoCache(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+	if err := ra.Prefetch([]Range{{0, 100}}); err != ErrNoCache {
+		t.Errorf("Prefetch error = %v; want %v", err, ErrNoCache)
+	}
+}
+
+func TestPrefetchMaxConcurrency(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data}
+	var inFlight, maxInFlight int32
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxInFlight)
+			if n <= m || atomic.CompareAndSwapInt32(&maxInFlight, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		h.ServeHTTP(w, r)
+	})
+	ra := newTestReaderAt(t, ts.URL, nil, WithBlockCache(100, 0), WithMaxConcurrency(2))
+	if err := ra.Prefetch([]Range{{0, 10}, {200, 10}, {400, 10}, {600, 10}, {800, 10}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&maxInFlight); n > 2 {
+		t.Errorf("%d concurrent requests; want at most 2", n)
+	}
+}
@@ -0,0 +1,176 @@
+package httpreaderat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	stderrors "errors"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// CachingReaderAt is io.ReaderAt implementation which serves a remote file
+// from a persistent copy on local disk. It is created with
+// NewCachingReaderAt. It is safe for concurrent use.
+type CachingReaderAt struct {
+	f    *os.File
+	meta Meta
+	size int64
+}
+
+var _ io.ReaderAt = (*CachingReaderAt)(nil)
+
+// ErrNoValidator error is returned by NewCachingReaderAt if the server
+// does not send an "ETag" or a "Last-Modified" header which could be used
+// for identifying the version of the remote file.
+var ErrNoValidator = errors.New("no etag or last-modified header")
+
+// NewCachingReaderAt creates a new CachingReaderAt. The remote file
+// specified by the prototype request req is probed as in New. If cacheDir
+// contains a copy of the same version of the file, it is used. Otherwise
+// the whole file is downloaded to cacheDir with a single GET request. The
+// copy is named by a hash of the URL and the "ETag" header (or the
+// "Last-Modified" header and the size if there is no ETag) and it is
+// retained after Close, so that it can be reused by later calls and
+// processes. Removing stale copies is the responsibility of the caller.
+// The Options are passed to New.
+func NewCachingReaderAt(client *http.Client, req *http.Request, cacheDir string, opts ...Option) (c *CachingReaderAt, err error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	var meta Meta
+	ra, err := New(client, req, nil, opts...)
+	if err == nil {
+		meta = ra.Meta()
+		ra.Close()
+	} else {
+		var nre *NoRangeError
+		if !stderrors.As(err, &nre) {
+			return nil, err
+		}
+		meta = nre.Meta
+	}
+	if meta.ETag == "" && meta.LastModified == "" {
+		return nil, ErrNoValidator
+	}
+	name := filepath.Join(cacheDir, cacheFileName(req, meta))
+
+	f, err := os.Open(name)
+	if err == nil {
+		c, err = newCachingReaderAt(f, meta)
+		if err == nil {
+			return c, nil
+		}
+		// the copy is truncated or otherwise unusable, download again
+	}
+	err = downloadFile(client, req, meta, name)
+	if err != nil {
+		return nil, err
+	}
+	f, err = os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return newCachingReaderAt(f, meta)
+}
+
+func newCachingReaderAt(f *os.File, meta Meta) (*CachingReaderAt, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if meta.Size != -1 && fi.Size() != meta.Size {
+		f.Close()
+		return nil, ErrValidationFailed
+	}
+	meta.Size = fi.Size()
+	return &CachingReaderAt{f: f, meta: meta, size: fi.Size()}, nil
+}
+
+// cacheFileName returns the name of the cached copy of the version of the
+// remote file described by meta.
+func cacheFileName(req *http.Request, meta Meta) string {
+	h := sha256.New()
+	io.WriteString(h, req.URL.String())
+	if meta.ETag != "" {
+		io.WriteString(h, "\x00etag\x00"+meta.ETag)
+	} else {
+		io.WriteString(h, "\x00last-modified\x00"+meta.LastModified)
+		io.WriteString(h, "\x00size\x00"+strconv.FormatInt(meta.Size, 10))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// downloadFile downloads the remote file to name. The data is first
+// written to a temporary file in the same directory, which is renamed to
+// name once the download is complete and matches meta.
+func downloadFile(client *http.Client, req *http.Request, meta Meta, name string) (err error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return errors.Wrap(redactError(err), "http request error")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return newHTTPError(resp)
+	}
+	m := getMeta(resp)
+	if m.ETag != meta.ETag || m.LastModified != meta.LastModified {
+		return ErrValidationFailed
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".httpreaderat-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	size, err := io.Copy(tmp, resp.Body)
+	if err != nil {
+		return err
+	}
+	if meta.Size != -1 && size != meta.Size {
+		return ErrValidationFailed
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// ReadAt reads len(b) bytes from the cached copy of the remote file
+// starting at byte offset off.
+func (c *CachingReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	return c.f.ReadAt(p, off)
+}
+
+// Size returns the size of the file.
+func (c *CachingReaderAt) Size() int64 {
+	return c.size
+}
+
+// Meta returns the metadata of the remote file.
+func (c *CachingReaderAt) Meta() Meta {
+	return c.meta
+}
+
+// Name returns the name of the cached copy of the remote file.
+func (c *CachingReaderAt) Name() string {
+	return c.f.Name()
+}
+
+// Close closes the cached copy. The copy is not removed.
+func (c *CachingReaderAt) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,97 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCachingReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	var mu sync.Mutex
+	etag := `"1"`
+	var downloads int32
+	h := &rangeHandler{data: data}
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		w.Header().Set("ETag", etag)
+		mu.Unlock()
+		if r.Header.Get("Range") == "" {
+			atomic.AddInt32(&downloads, 1)
+		}
+		h.ServeHTTP(w, r)
+	})
+	dir := t.TempDir()
+	open := func() *CachingReaderAt {
+		t.Helper()
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		c, err := NewCachingReaderAt(nil, req, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 100)
+		if n, err := c.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+			t.Errorf("ReadAt = %d, %v", n, err)
+		}
+		if c.Size() != int64(len(data)) {
+			t.Errorf("Size = %d; want %d", c.Size(), len(data))
+		}
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	c1 := open()
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("downloads = %d; want 1", n)
+	}
+	// the copy is reused across calls
+	c2 := open()
+	if n := atomic.LoadInt32(&downloads); n != 1 {
+		t.Errorf("downloads = %d; want 1", n)
+	}
+	if c1.Name() != c2.Name() {
+		t.Errorf("copy %s is not reused, got %s", c1.Name(), c2.Name())
+	}
+
+	// a new version of the file is downloaded again
+	mu.Lock()
+	etag = `"2"`
+	mu.Unlock()
+	c3 := open()
+	if n := atomic.LoadInt32(&downloads); n != 2 {
+		t.Errorf("downloads = %d; want 2", n)
+	}
+	if c3.Name() == c1.Name() {
+		t.Error("the copy of the previous version is used")
+	}
+}
+
+func TestCachingReaderAtNoValidator(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	if _, err := NewCachingReaderAt(nil, req, t.TempDir()); err != ErrNoValidator {
+		t.Errorf("NewCachingReaderAt error = %v; want %v", err, ErrNoValidator)
+	}
+}
+
+func TestCachingReaderAtNoRange(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, noRangeHandler(data))
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	c, err := NewCachingReaderAt(nil, req, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	p := make([]byte, len(data))
+	if n, err := c.ReadAt(p, 0); n != len(p) || err != nil || !bytes.Equal(p, data) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+}
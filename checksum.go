@@ -0,0 +1,132 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ErrChecksumMismatch error is returned by ChecksumReaderAt if the checksum
+// of the file does not match the expected checksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrChecksumIncomplete error is returned by ChecksumReaderAt.Verify if
+// the whole file has not been read yet.
+var ErrChecksumIncomplete = errors.New("checksum incomplete")
+
+// ChecksumReaderAt is io.ReaderAt decorator which verifies the checksum
+// of a file read sequentially from start to end with ReadAt calls. It is
+// created with NewChecksumReaderAt. It is safe for concurrent use if the
+// underlying io.ReaderAt is.
+type ChecksumReaderAt struct {
+	ra       io.ReaderAt
+	size     int64
+	expected []byte
+
+	mu   sync.Mutex
+	h    hash.Hash
+	pos  int64 // end of the contiguous range hashed so far
+	done bool
+	err  error // result of the verification once done
+}
+
+var _ io.ReaderAt = (*ChecksumReaderAt)(nil)
+
+// NewChecksumReaderAt creates a new ChecksumReaderAt reading a file of
+// size bytes from ra. The checksum is computed with algo ("md5", "sha1",
+// "sha256" or "sha512") and compared to the hex encoded hexsum.
+//
+// The data returned by ReadAt is hashed as long as the reads continue
+// from the end of the data hashed so far; overlapping reads are fine.
+// Reads starting after it are served but not hashed, so out of order and
+// partial reads defer the verification until the skipped data has been
+// read. The ReadAt call which reaches the end of the file verifies the
+// checksum and returns ErrChecksumMismatch if it does not match.
+func NewChecksumReaderAt(ra io.ReaderAt, size int64, algo, hexsum string) (*ChecksumReaderAt, error) {
+	var h hash.Hash
+	switch strings.ToLower(algo) {
+	case "md5":
+		h = md5.New()
+	case "sha1":
+		h = sha1.New()
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return nil, errors.Errorf("unsupported checksum algorithm %q", algo)
+	}
+	expected, err := hex.DecodeString(hexsum)
+	if err != nil || len(expected) != h.Size() {
+		return nil, errors.New("invalid checksum")
+	}
+	c := &ChecksumReaderAt{
+		ra:       ra,
+		size:     size,
+		expected: expected,
+		h:        h,
+	}
+	if size <= 0 {
+		c.finish()
+	}
+	return c, nil
+}
+
+// finish verifies the checksum once the whole file has been hashed.
+func (c *ChecksumReaderAt) finish() {
+	c.done = true
+	if !bytes.Equal(c.h.Sum(nil), c.expected) {
+		c.err = ErrChecksumMismatch
+	}
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off.
+func (c *ChecksumReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = c.ra.ReadAt(p, off)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.done || off > c.pos || off+int64(n) <= c.pos {
+		return n, err
+	}
+	data := p[c.pos-off : n]
+	if rem := c.size - c.pos; int64(len(data)) > rem {
+		data = data[:rem]
+	}
+	c.h.Write(data)
+	c.pos += int64(len(data))
+	if c.pos == c.size {
+		c.finish()
+		if c.err != nil && (err == nil || err == io.EOF) {
+			err = c.err
+		}
+	}
+	return n, err
+}
+
+// Size returns the size of the file.
+func (c *ChecksumReaderAt) Size() int64 {
+	return c.size
+}
+
+// Verify returns nil if the whole file has been read and its checksum
+// matches, ErrChecksumMismatch if it does not match and
+// ErrChecksumIncomplete if the whole file has not been read yet.
+func (c *ChecksumReaderAt) Verify() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.done {
+		return ErrChecksumIncomplete
+	}
+	return c.err
+}
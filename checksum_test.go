@@ -0,0 +1,82 @@
+package httpreaderat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func TestChecksumReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	sum := sha256.Sum256(data)
+	hexsum := hex.EncodeToString(sum[:])
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+
+	// sequential overlapping reads
+	c, err := NewChecksumReaderAt(ra, ra.Size(), "SHA256", hexsum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 400)
+	for _, off := range []int64{0, 300, 700} {
+		if err := c.Verify(); err != ErrChecksumIncomplete {
+			t.Errorf("Verify() before offset %d = %v; want ErrChecksumIncomplete", off, err)
+		}
+		want := len(data) - int(off)
+		if want > len(p) {
+			want = len(p)
+		}
+		if n, err := c.ReadAt(p, off); n != want || err != nil && err != io.EOF {
+			t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+		}
+	}
+	if err := c.Verify(); err != nil {
+		t.Errorf("Verify() = %v; want nil", err)
+	}
+
+	// reads out of order defer the verification
+	c, err = NewChecksumReaderAt(ra, ra.Size(), "sha256", hexsum)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.ReadAt(p, 500)
+	if err := c.Verify(); err != ErrChecksumIncomplete {
+		t.Errorf("Verify() after skipping = %v; want ErrChecksumIncomplete", err)
+	}
+	c.ReadAt(p, 0)
+	c.ReadAt(p, 400)
+	if err := c.Verify(); err != ErrChecksumIncomplete {
+		t.Errorf("Verify() before rereading = %v; want ErrChecksumIncomplete", err)
+	}
+	c.ReadAt(p, 600)
+	if err := c.Verify(); err != nil {
+		t.Errorf("Verify() = %v; want nil", err)
+	}
+
+	// wrong checksum
+	other := sha256.Sum256(data[1:])
+	c, err = NewChecksumReaderAt(ra, ra.Size(), "sha256", hex.EncodeToString(other[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ReadAt(make([]byte, len(data)), 0); err != ErrChecksumMismatch {
+		t.Errorf("ReadAt error = %v; want ErrChecksumMismatch", err)
+	}
+	if err := c.Verify(); err != ErrChecksumMismatch {
+		t.Errorf("Verify() = %v; want ErrChecksumMismatch", err)
+	}
+}
+
+func TestChecksumReaderAtInvalid(t *testing.T) {
+	for _, tc := range []struct{ algo, hexsum string }{
+		{"crc32", "00000000"},
+		{"md5", "xyz"},
+		{"md5", "00"},
+	} {
+		if _, err := NewChecksumReaderAt(nil, 0, tc.algo, tc.hexsum); err == nil {
+			t.Errorf("NewChecksumReaderAt(%q, %q) succeeded", tc.algo, tc.hexsum)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package httpreaderat
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// CopyRange copies n bytes of the remote file starting at byte offset off
+// to w. The data is streamed from the body of a single HTTP response
+// directly to w, so the range is not buffered in memory as a whole. This
+// is useful for example for extracting a stored zip member to a file.
+// It returns the number of bytes copied and the error, if any. If the
+// range extends beyond the end of the file, the bytes up to the end are
+// copied and io.EOF is returned. The block cache and read batching are
+// bypassed. The response is validated as with ReadAt.
+func (ra *HTTPReaderAt) CopyRange(w io.Writer, off, n int64) (written int64, err error) {
+	end, err := rangeEnd(off, n)
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if ra.usebs {
+		written, err = io.Copy(w, io.NewSectionReader(ra.bs, off, n))
+		if err == nil && written < n {
+			err = io.EOF
+		}
+		return written, err
+	}
+	ctx, cancel := ra.operationContext(context.Background())
+	defer cancel()
+
+	reqFirst, reqLast := off, end-1
+	var returnErr error
+	if size := ra.Size(); size != -1 && reqLast > size-1 {
+		reqLast = size - 1
+		returnErr = io.EOF
+		if reqLast < reqFirst {
+			return 0, io.EOF
+		}
+	}
+	body, err := ra.openRange(ctx, reqFirst, reqLast)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	written, err = io.Copy(w, body)
+	atomic.AddInt64(&ra.counters.bytesFetched, written)
+	if err != nil && ctx.Err() != nil {
+		return written, ctx.Err()
+	}
+	if err == nil && written < n {
+		// the body was truncated or the server returned a shorter range
+		err = io.EOF
+	}
+	if err == nil && returnErr != nil {
+		err = returnErr
+	}
+	return written, err
+}
+
+// rangeBody is the body of a validated range response limited to the
+// received range. Closing it also frees the WithMaxConcurrency slot.
+type rangeBody struct {
+	io.Reader
+	body    io.ReadCloser
+	release func()
+}
+
+func (b *rangeBody) Close() error {
+	drainBody(b.body)
+	err := b.body.Close()
+	b.release()
+	return err
+}
+
+// ReadToEnd returns the contents of the remote file from byte offset off
+// to the end of the file. The data is streamed from the body of a single
+// HTTP response with an open ended Range Request ("bytes=off-"), so the
+// size of the file does not need to be known. This is useful for example
+// for following a growing log file. The returned io.ReadCloser must be
+// closed to close the HTTP response body and to free the slot taken
+// because of WithMaxConcurrency.
+func (ra *HTTPReaderAt) ReadToEnd(off int64) (io.ReadCloser, error) {
+	if off < 0 {
+		return nil, errNegativeOffset
+	}
+	size := ra.Size()
+	if ra.usebs {
+		return ioutil.NopCloser(io.NewSectionReader(ra.bs, off, maxInt-off)), nil
+	}
+	if size != -1 && off >= size && !ra.refreshSizeOnEOF && !ra.liveSize {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	reqLast := int64(-1)
+	if ra.rangeEncoder != nil {
+		if size == -1 {
+			return nil, errors.New("open ended range requires known size with range encoder")
+		}
+		reqLast = size - 1
+	}
+	return ra.openRange(context.Background(), off, reqLast)
+}
+
+// openRange makes a Range Request for bytes reqFirst...reqLast (inclusive)
+// and validates the response. If reqLast is negative, the range extends to
+// the end of the file. The returned body yields the received range, which
+// may be shorter than requested at the end of the file.
+func (ra *HTTPReaderAt) openRange(ctx context.Context, reqFirst, reqLast int64) (io.ReadCloser, error) {
+	req := ra.copyReq().WithContext(ctx)
+	if ra.rangeEncoder != nil {
+		ra.rangeEncoder(req, reqFirst, reqLast)
+	} else if reqLast < 0 {
+		req.Header.Set(ra.rangeHeaderName(), fmt.Sprintf("bytes=%d-", reqFirst))
+	} else {
+		req.Header.Set(ra.rangeHeaderName(), fmt.Sprintf("bytes=%d-%d", reqFirst, reqLast))
+	}
+	if ra.dataEncoding != "" {
+		req.Header.Set("Accept-Encoding", ra.dataEncoding)
+	}
+	requestIdentity(req)
+
+	release := func() {}
+	if ra.sem != nil {
+		select {
+		case ra.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		release = func() { <-ra.sem }
+	}
+	atomic.AddInt64(&ra.counters.networkReads, 1)
+	resp, err := ra.do(req)
+	if err != nil {
+		release()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.Wrap(err, "http request error")
+	}
+	body, err := ra.checkRange(resp, reqFirst, reqLast)
+	if err != nil {
+		resp.Body.Close()
+		release()
+		return nil, err
+	}
+	return &rangeBody{Reader: body, body: resp.Body, release: release}, nil
+}
+
+// checkRange validates the response to a Range Request for bytes
+// reqFirst...reqLast and returns its body limited to the received range.
+func (ra *HTTPReaderAt) checkRange(resp *http.Response, reqFirst, reqLast int64) (io.Reader, error) {
+	first, last, err := ra.checkResponse(resp, reqFirst, reqLast, false)
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(resp.Body, last-first+1), nil
+}
@@ -0,0 +1,83 @@
+package httpreaderat
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// ErrInsufficientDiskSpace error is returned by New if WithDiskSpaceCheck
+// is enabled and there is not enough free space for buffering the file to
+// a temporary file.
+var ErrInsufficientDiskSpace = errors.New("insufficient disk space")
+
+// diskSpaceMargin is the amount of free space which is left untouched by
+// WithDiskSpaceCheck.
+const diskSpaceMargin = 64 * 1024 * 1024
+
+// tempDirStore is implemented by Stores which buffer data to a temporary
+// file on the operating system file system. tempDir returns the temporary
+// file directory used for storing size bytes, if any.
+type tempDirStore interface {
+	tempDir(size int64) (dir string, ok bool)
+}
+
+func (s *StoreFile) tempDir(size int64) (string, bool) {
+	if _, ok := s.fs().(OSFileSystem); !ok {
+		return "", false
+	}
+	if s.dir == "" {
+		return os.TempDir(), true
+	}
+	return s.dir, true
+}
+
+func (s *LimitedStore) tempDir(size int64) (string, bool) {
+	// data exceeding the limit is moved to the secondary store
+	if size <= s.limit {
+		return storeTempDir(s.primary, size)
+	}
+	return storeTempDir(s.secondary, size)
+}
+
+func (s *TieredStore) tempDir(size int64) (string, bool) {
+	var offset int64
+	for _, t := range s.tiers {
+		if size <= offset {
+			break
+		}
+		if dir, ok := storeTempDir(t.Store, size-offset); ok {
+			return dir, true
+		}
+		offset += t.Limit
+	}
+	return "", false
+}
+
+// storeTempDir returns the temporary file directory used by s for storing
+// size bytes, if any.
+func storeTempDir(s Store, size int64) (string, bool) {
+	if t, ok := s.(tempDirStore); ok {
+		return t.tempDir(size)
+	}
+	return "", false
+}
+
+// checkDiskSpace checks that the temporary file directory of the Store
+// has room for the file. See WithDiskSpaceCheck.
+func (ra *HTTPReaderAt) checkDiskSpace() error {
+	if !ra.diskSpaceCheck || ra.meta.Size < 0 {
+		return nil
+	}
+	dir, ok := storeTempDir(ra.bs, ra.meta.Size)
+	if !ok {
+		return nil
+	}
+	free, err := freeDiskSpace(dir)
+	if err != nil {
+		return errors.Wrap(err, "disk space check error")
+	}
+	if free >= 0 && free-diskSpaceMargin < ra.meta.Size {
+		return ErrInsufficientDiskSpace
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !freebsd && !linux
+// +build !darwin,!freebsd,!linux
+
+package httpreaderat
+
+// freeDiskSpace returns -1 because free space can not be determined on
+// this platform.
+func freeDiskSpace(dir string) (int64, error) {
+	return -1, nil
+}
@@ -0,0 +1,39 @@
+package httpreaderat
+
+import "testing"
+
+func TestStoreTempDir(t *testing.T) {
+	dir := t.TempDir()
+	file := func() Store { return NewStoreFileWithOptions(dir, "test", 0) }
+	tests := []struct {
+		name   string
+		bs     Store
+		size   int64
+		wantOK bool
+	}{
+		{"memory", NewStoreMemory(), 1000, false},
+		{"file", file(), 1000, true},
+		{"limited within memory", NewLimitedStore(NewStoreMemory(), 1000, file()), 1000, false},
+		{"limited beyond memory", NewLimitedStore(NewStoreMemory(), 1000, file()), 1001, true},
+		{"limited without file", NewLimitedStore(NewStoreMemory(), 1000, nil), 1001, false},
+		{"tiered within memory", NewTieredStore(Tier{NewStoreMemory(), 1000}, Tier{file(), 5000}), 1000, false},
+		{"tiered beyond memory", NewTieredStore(Tier{NewStoreMemory(), 1000}, Tier{file(), 5000}), 1001, true},
+	}
+	for _, tt := range tests {
+		got, ok := storeTempDir(tt.bs, tt.size)
+		if ok != tt.wantOK || (ok && got != dir) {
+			t.Errorf("%s: storeTempDir = %q, %v; want %v", tt.name, got, ok, tt.wantOK)
+		}
+		tt.bs.Close()
+	}
+
+	// the default Store only uses a temporary file beyond 1 MB
+	bs := NewDefaultStore()
+	defer bs.Close()
+	if _, ok := storeTempDir(bs, 100); ok {
+		t.Error("NewDefaultStore uses a temporary file for 100 bytes")
+	}
+	if _, ok := storeTempDir(bs, 2*1024*1024); !ok {
+		t.Error("NewDefaultStore does not use a temporary file for 2 MB")
+	}
+}
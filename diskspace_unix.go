@@ -0,0 +1,16 @@
+//go:build darwin || freebsd || linux
+// +build darwin freebsd linux
+
+package httpreaderat
+
+import "syscall"
+
+// freeDiskSpace returns the number of bytes available to unprivileged
+// users on the file system containing dir.
+func freeDiskSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return -1, err
+	}
+	return int64(uint64(st.Bavail) * uint64(st.Bsize)), nil
+}
@@ -0,0 +1,87 @@
+package httpreaderat
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// EncryptedReaderAt is io.ReaderAt implementation which decrypts an AES-CTR
+// encrypted file accessed through another io.ReaderAt (typically
+// a HTTPReaderAt). Because CTR mode is seekable, arbitrary ranges can be
+// decrypted without reading the preceding data. It is safe for concurrent
+// use if the underlying io.ReaderAt is.
+type EncryptedReaderAt struct {
+	ra    io.ReaderAt
+	block cipher.Block
+	iv    []byte
+	size  int64
+}
+
+var _ io.ReaderAt = (*EncryptedReaderAt)(nil)
+
+// NewEncryptedReaderAt creates a new EncryptedReaderAt decrypting ra with
+// AES-CTR using key (16, 24 or 32 bytes) and the initial counter block iv
+// (16 bytes). The size of the file is size bytes.
+func NewEncryptedReaderAt(ra io.ReaderAt, key, iv []byte, size int64) (*EncryptedReaderAt, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid IV length")
+	}
+	return &EncryptedReaderAt{
+		ra:    ra,
+		block: block,
+		iv:    append([]byte(nil), iv...),
+		size:  size,
+	}, nil
+}
+
+// Size returns the size of the file.
+func (e *EncryptedReaderAt) Size() int64 {
+	return e.size
+}
+
+// ReadAt reads and decrypts len(b) bytes starting at byte offset off.
+func (e *EncryptedReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	if off >= e.size {
+		return 0, io.EOF
+	}
+	if rem := e.size - off; int64(len(p)) > rem {
+		p = p[:rem]
+		err = io.EOF
+	}
+	n, err2 := e.ra.ReadAt(p, off)
+	if err2 != nil && !(err2 == io.EOF && n == len(p)) {
+		err = err2
+	}
+
+	// position the key stream to off: the counter block of the AES block
+	// containing off, then discard the bytes preceding off in that block
+	stream := cipher.NewCTR(e.block, e.counter(off/aes.BlockSize))
+	if skip := int(off % aes.BlockSize); skip > 0 {
+		var discard [aes.BlockSize]byte
+		stream.XORKeyStream(discard[:skip], discard[:skip])
+	}
+	stream.XORKeyStream(p[:n], p[:n])
+	return n, err
+}
+
+// counter returns the counter block for AES block number blk, which is iv
+// incremented by blk as a 128-bit big-endian integer.
+func (e *EncryptedReaderAt) counter(blk int64) []byte {
+	ctr := append([]byte(nil), e.iv...)
+	carry := uint64(blk)
+	for i := len(ctr) - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(ctr[i]) + carry&0xff
+		ctr[i] = byte(sum)
+		carry = carry>>8 + sum>>8
+	}
+	return ctr
+}
@@ -0,0 +1,55 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+)
+
+func TestEncryptedReaderAt(t *testing.T) {
+	plain := makeTestData(1000)
+	key := bytes.Repeat([]byte{7}, 32)
+	ivs := [][]byte{
+		make([]byte, aes.BlockSize),
+		// the counter carries over the low bytes
+		append(bytes.Repeat([]byte{0}, 8), bytes.Repeat([]byte{0xff}, 8)...),
+	}
+	for _, iv := range ivs {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		enc := make([]byte, len(plain))
+		cipher.NewCTR(block, iv).XORKeyStream(enc, plain)
+
+		ts := newTestServer(t, enc, nil)
+		e, err := NewEncryptedReaderAt(newTestReaderAt(t, ts.URL, nil), key, iv, int64(len(enc)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range []Range{{0, 1000}, {5, 10}, {16, 16}, {17, 300}, {999, 1}} {
+			p := make([]byte, r.Len)
+			n, err := e.ReadAt(p, r.Off)
+			if n != len(p) || err != nil || !bytes.Equal(p, plain[r.Off:r.Off+r.Len]) {
+				t.Errorf("iv %x: ReadAt(%d, %d) = %d, %v", iv, r.Off, r.Len, n, err)
+			}
+		}
+		p := make([]byte, 100)
+		n, err := e.ReadAt(p, 950)
+		if n != 50 || err != io.EOF || !bytes.Equal(p[:n], plain[950:]) {
+			t.Errorf("iv %x: ReadAt(950) = %d, %v; want 50, EOF", iv, n, err)
+		}
+	}
+}
+
+func TestEncryptedReaderAtInvalid(t *testing.T) {
+	r := bytes.NewReader(nil)
+	if _, err := NewEncryptedReaderAt(r, make([]byte, 10), make([]byte, aes.BlockSize), 0); err == nil {
+		t.Error("NewEncryptedReaderAt accepted an invalid key")
+	}
+	if _, err := NewEncryptedReaderAt(r, make([]byte, 16), make([]byte, 8), 0); err == nil {
+		t.Error("NewEncryptedReaderAt accepted an invalid IV")
+	}
+}
@@ -0,0 +1,80 @@
+package httpreaderat
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// HTTPError error is returned if the server responds with an unexpected
+// HTTP status code. Credentials are redacted from URL and Header.
+type HTTPError struct {
+	StatusCode int         // HTTP status code, for example 404
+	Status     string      // HTTP status, for example "404 Not Found"
+	URL        string      // request URL with password redacted
+	Header     http.Header // response header with cookies redacted
+}
+
+func newHTTPError(resp *http.Response) *HTTPError {
+	e := &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Header:     redactHeader(resp.Header),
+	}
+	if resp.Request != nil {
+		e.URL = redactURL(resp.Request.URL)
+	}
+	return e
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("http request error: %s", e.Status)
+}
+
+// ProbeError error is returned by New if the remote file could not be
+// accessed after the server responded to the probe request. It carries
+// the status and the header of the probe response, for example to allow
+// inspecting a "WWW-Authenticate" challenge or the "Accept-Ranges" header.
+// Err is the underlying error, such as a NoRangeError or an HTTPError;
+// errors.Is and errors.As see through ProbeError.
+type ProbeError struct {
+	StatusCode int         // HTTP status code of the probe response
+	Status     string      // HTTP status of the probe response
+	Header     http.Header // probe response header with cookies redacted
+	Err        error       // underlying error
+}
+
+func newProbeError(info respInfo, err error) *ProbeError {
+	return &ProbeError{
+		StatusCode: info.StatusCode,
+		Status:     info.Status,
+		Header:     redactHeader(info.Header),
+		Err:        err,
+	}
+}
+
+func (e *ProbeError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// RedirectError error is returned if following a redirect fails, for
+// example because of a redirect loop. The redirect policy can be set with
+// WithCheckRedirect.
+type RedirectError struct {
+	URL       string // redirect target URL with password redacted
+	Redirects int    // number of redirects followed before the failure
+	Err       error  // error returned by the redirect policy
+}
+
+func (e *RedirectError) Error() string {
+	return fmt.Sprintf("redirect error after %d redirects: %v", e.Redirects, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *RedirectError) Unwrap() error {
+	return e.Err
+}
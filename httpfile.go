@@ -0,0 +1,64 @@
+package httpreaderat
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// httpFile is http.File implementation backed by HTTPReaderAt.
+type httpFile struct {
+	*io.SectionReader
+	ra   *HTTPReaderAt
+	name string
+}
+
+var _ http.File = (*httpFile)(nil)
+
+// NewHTTPFile returns an http.File reading the remote file accessed
+// through ra, for example for re-serving it with http.FileServer or
+// http.ServeContent. Stat returns the size and the modification time of
+// the remote file with the given name; the Sys method of the returned
+// os.FileInfo returns the Meta of the remote file, which includes the
+// content type. Each returned http.File has its own read position, so
+// a new one should be created for each HTTP request. Closing the
+// http.File does not close ra.
+func NewHTTPFile(ra *HTTPReaderAt, name string) http.File {
+	return &httpFile{
+		SectionReader: io.NewSectionReader(ra, 0, ra.Size()),
+		ra:            ra,
+		name:          name,
+	}
+}
+
+func (f *httpFile) Close() error {
+	return nil
+}
+
+func (f *httpFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *httpFile) Stat() (os.FileInfo, error) {
+	meta := f.ra.Meta()
+	fi := &httpFileInfo{name: f.name, meta: meta}
+	if t, err := http.ParseTime(meta.LastModified); err == nil {
+		fi.modTime = t
+	}
+	return fi, nil
+}
+
+// httpFileInfo is os.FileInfo describing the remote file.
+type httpFileInfo struct {
+	name    string
+	meta    Meta
+	modTime time.Time
+}
+
+func (fi *httpFileInfo) Name() string       { return fi.name }
+func (fi *httpFileInfo) Size() int64        { return fi.meta.Size }
+func (fi *httpFileInfo) Mode() os.FileMode  { return 0444 }
+func (fi *httpFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *httpFileInfo) IsDir() bool        { return false }
+func (fi *httpFileInfo) Sys() interface{}   { return fi.meta }
@@ -9,12 +9,20 @@
 package httpreaderat
 
 import (
+	"context"
 	"fmt"
 	"github.com/pkg/errors"
 	"io"
+	"io/ioutil"
+	"math"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // HTTPReaderAt is io.ReaderAt implementation that makes HTTP Range Requests.
@@ -23,10 +31,69 @@ import (
 type HTTPReaderAt struct {
 	client *http.Client
 	req    *http.Request
-	meta   meta
+	meta   Meta
+	metaMu sync.RWMutex
 
 	bs    Store
+	bsf   StoreFactory
 	usebs bool
+	ownbs bool
+
+	header http.Header  // base headers of the requests, not mutated
+	reqMu  sync.RWMutex // guards req and header replaced by WithURLRefresher
+
+	captureCookies      bool
+	probeOpenEnded      bool
+	liveSize            bool
+	localURLs           bool
+	preBufferCheck      func(meta Meta) error
+	expectedType        string
+	autoStore           bool
+	strictProbe         bool
+	diskSpaceCheck      bool
+	revalidate          bool
+	strictEOF           bool
+	resumableReads      bool
+	refreshSizeOnEOF    bool
+	strictBodyLength    bool
+	etagOptional        bool
+	disableFallback     bool
+	followPermanent     bool
+	requireKnownSize    bool
+	checkTotal          bool
+	strictContentLength bool
+
+	cancelBuffering    <-chan struct{}
+	prober             Prober
+	opTimeout          time.Duration
+	probeEncoding      string
+	dataEncoding       string
+	tracer             Tracer
+	rangeEncoder       func(req *http.Request, first, last int64)
+	observer           func(off int64, p []byte)
+	checkRedirect      func(req *http.Request, via []*http.Request) error
+	urlRefresher       func(ctx context.Context) (*http.Request, error)
+	retryAttempts      int
+	retryDelay         time.Duration
+	retryPredicate     func(resp *http.Response, err error) bool
+	backoff            Backoff
+	validationInterval time.Duration
+	fallbackDecider    func(resp *http.Response) bool
+	bufPool            BufferPool
+	rangeHeader        string
+	probeSize          int
+
+	autoBufferFraction float64
+	autoBufferMu       sync.Mutex
+	autoBufferTried    bool
+	autoBuffered       int32 // accessed atomically, 1 if buffered
+
+	probeData   []byte   // data received by the probe, not mutated
+	resolvedURL *url.URL // final URL of the probe request, not mutated
+	cache       *blockCache
+	sem         chan struct{}
+	batcher     *readBatcher
+	counters    *counters
 }
 
 var _ io.ReaderAt = (*HTTPReaderAt)(nil)
@@ -35,50 +102,466 @@ var _ io.ReaderAt = (*HTTPReaderAt)(nil)
 // our feet.
 var ErrValidationFailed = errors.New("validation failed")
 
-// ErrNoRange error is returned if the server does not support range
-// requests and there is no Store defined for buffering the file.
+// ErrInconsistentProbe error is returned by New if WithStrictProbe is
+// enabled and the Content-Range of the probe response does not match the
+// requested range or reports an impossible total length.
+var ErrInconsistentProbe = errors.New("inconsistent probe response")
+
+// ErrUnexpectedContentType error is returned by New if the Content-Type
+// of the remote file does not match the one given with
+// WithExpectedContentType.
+var ErrUnexpectedContentType = errors.New("unexpected content type")
+
+// ErrBodyTooLong error is returned if WithStrictBodyLength is enabled and
+// the body of a response is longer than its Content-Range header states.
+var ErrBodyTooLong = errors.New("response body longer than content-range")
+
+// ErrContentLengthMismatch error is returned if WithStrictContentLength is
+// enabled and the length of a response body differs from its
+// "Content-Length" header.
+var ErrContentLengthMismatch = errors.New("body length does not match content-length")
+
+// ErrUnknownSize error is returned by New if WithRequireKnownSize is
+// enabled and the size of the remote file can not be determined.
+var ErrUnknownSize = errors.New("unknown size")
+
+// ErrRangeEncoded error is returned by ReadAt if the server (or a proxy)
+// responds to a Range Request with a compressed partial response, that is
+// with a "Content-Encoding" header. Such a body does not correspond to the
+// requested byte range of the file. Requesting uncompressed data with
+// WithAcceptEncoding(probe, "identity") may help.
+var ErrRangeEncoded = errors.New("partial response has content-encoding")
+
+// ErrBufferingCanceled error is returned by New if the download of the
+// file to the Store is canceled with WithCancelBuffering.
+var ErrBufferingCanceled = errors.New("buffering canceled")
+
+// ErrNoRange error is returned (wrapped in NoRangeError) if the server
+// does not support range requests and there is no Store defined for
+// buffering the file.
 var ErrNoRange = errors.New("server does not support range requests")
 
+// NoRangeError error is returned by New if the server does not support
+// range requests and there is no Store defined for buffering the file.
+// It carries the metadata of the remote file, so that the caller can for
+// example retry with a Store suitable for the size of the file.
+// errors.Is(err, ErrNoRange) reports true for it.
+type NoRangeError struct {
+	Meta Meta
+}
+
+func (e *NoRangeError) Error() string {
+	return ErrNoRange.Error()
+}
+
+// Unwrap returns ErrNoRange.
+func (e *NoRangeError) Unwrap() error {
+	return ErrNoRange
+}
+
+// ErrRangeSupportLost error is returned by ReadAt if the server responds
+// to a Range Request with the whole file although it supported Range
+// Requests when New was called. errors.Is(err, ErrNoRange) reports true for
+// it as well.
+var ErrRangeSupportLost error = rangeSupportLostError{}
+
+type rangeSupportLostError struct{}
+
+func (rangeSupportLostError) Error() string {
+	return "server suddenly stopped supporting range requests"
+}
+
+// Unwrap returns ErrNoRange.
+func (rangeSupportLostError) Unwrap() error {
+	return ErrNoRange
+}
+
+// Range is a byte range of the remote file starting at byte offset Off
+// and having length of Len bytes.
+type Range struct {
+	Off int64
+	Len int64
+}
+
 // New creates a new HTTPReaderAt. If nil is passed as http.Client, then
 // http.DefaultClient is used. The supplied http.Request is used as a
 // prototype for requests. It is copied before making the actual request.
 // It is an error to specify any other HTTP method than "GET".
 // A Store can be supplied to enable fallback mechanism in case
 // the server does not support HTTP Range Requests.
-func New(client *http.Client, req *http.Request, bs Store) (ra *HTTPReaderAt, err error) {
+// Options can be supplied to alter the default behavior.
+func New(client *http.Client, req *http.Request, bs Store, opts ...Option) (ra *HTTPReaderAt, err error) {
+	return NewContext(context.Background(), client, req, bs, opts...)
+}
+
+// NewContext creates a new HTTPReaderAt like New, but the requests made
+// for probing the remote file, as well as buffering it to the Store if
+// the server does not support HTTP Range Requests, are made with the
+// context ctx. The context only applies to the construction; it does not
+// affect subsequent ReadAt calls.
+func NewContext(ctx context.Context, client *http.Client, req *http.Request, bs Store, opts ...Option) (ra *HTTPReaderAt, err error) {
+	return newHTTPReaderAt(ctx, client, req, bs, nil, opts)
+}
+
+// StoreFactory is a function which creates a new Store on demand.
+type StoreFactory func() Store
+
+// NewWithStoreFactory creates a new HTTPReaderAt like New, but instead of
+// a ready made Store it takes a StoreFactory which is invoked only if the
+// server does not support HTTP Range Requests and the fallback mechanism
+// is actually needed. This avoids allocating a Store in the common case.
+// The Store created by the factory is closed by calling Close on the
+// returned HTTPReaderAt.
+func NewWithStoreFactory(client *http.Client, req *http.Request, bsf StoreFactory, opts ...Option) (ra *HTTPReaderAt, err error) {
+	return newHTTPReaderAt(context.Background(), client, req, nil, bsf, opts)
+}
+
+var (
+	defaultStoreFactoryMu sync.RWMutex
+	defaultStoreFactory   StoreFactory = NewDefaultStore
+)
+
+// SetDefaultStoreFactory sets the package level StoreFactory used by
+// NewWithDefaults. The initial default is NewDefaultStore. If bsf is nil,
+// NewWithDefaults does not use a Store. It is meant to be called once
+// during program initialization; it does not affect HTTPReaderAt instances
+// which have already been created.
+func SetDefaultStoreFactory(bsf StoreFactory) {
+	defaultStoreFactoryMu.Lock()
+	defaultStoreFactory = bsf
+	defaultStoreFactoryMu.Unlock()
+}
+
+// NewWithDefaults creates a new HTTPReaderAt using http.DefaultClient and
+// the StoreFactory set with SetDefaultStoreFactory. The Store is created
+// only if it is needed and it is closed by calling Close on the returned
+// HTTPReaderAt.
+func NewWithDefaults(req *http.Request, opts ...Option) (ra *HTTPReaderAt, err error) {
+	defaultStoreFactoryMu.RLock()
+	bsf := defaultStoreFactory
+	defaultStoreFactoryMu.RUnlock()
+
+	return newHTTPReaderAt(context.Background(), http.DefaultClient, req, nil, bsf, opts)
+}
+
+func newHTTPReaderAt(ctx context.Context, client *http.Client, req *http.Request, bs Store, bsf StoreFactory, opts []Option) (ra *HTTPReaderAt, err error) {
 	if client == nil {
 		client = http.DefaultClient
 	}
 	if req.Method != "GET" {
-		return nil, errors.New("invalid HTTP method")
+		return nil, errInvalidMethod
 	}
 	ra = &HTTPReaderAt{
-		client: client,
-		req:    req,
-		bs:     bs,
+		client:   client,
+		req:      req,
+		header:   cloneHeader(req.Header),
+		bs:       bs,
+		bsf:      bsf,
+		counters: &counters{},
+	}
+	for _, opt := range opts {
+		opt(ra)
+	}
+	if ra.autoStore && ra.bs == nil && ra.bsf == nil {
+		ra.bsf = NewDefaultStore
 	}
-	// Make 1 byte Range Request to see if they are supported or not.
-	// Also stores the file metadata for later use.
-	_, err = ra.readAt(make([]byte, 1), 0, true)
+	ra.setCheckRedirect()
+	err = ra.init(ctx)
 	if err != nil {
+		ra.Close()
 		return nil, err
 	}
 	return ra, nil
 }
 
+var errInvalidMethod = errors.New("invalid HTTP method")
+
+// init probes the remote file and determines its metadata.
+func (ra *HTTPReaderAt) init(ctx context.Context) error {
+	err := ra.initMeta(ctx)
+	if err == nil && ra.requireKnownSize && ra.Size() == -1 {
+		err = ra.discoverSize(ctx)
+	}
+	return err
+}
+
+// discoverSize tries to determine the unknown size of the remote file with
+// HeadProber and UnsatisfiableRangeProber as with WithRequireKnownSize.
+func (ra *HTTPReaderAt) discoverSize(ctx context.Context) error {
+	for _, p := range []Prober{HeadProber{}, UnsatisfiableRangeProber{}} {
+		meta, _, err := p.Probe(ctx, ra.do, ra.copyReq())
+		if err != nil || meta.Size == -1 {
+			continue
+		}
+		if meta.ETag != ra.meta.ETag || meta.LastModified != ra.meta.LastModified {
+			return ErrValidationFailed
+		}
+		ra.meta.Size = meta.Size
+		return nil
+	}
+	return ErrUnknownSize
+}
+
+// initMeta probes the remote file and stores its metadata.
+func (ra *HTTPReaderAt) initMeta(ctx context.Context) (err error) {
+	ra.probeData = nil
+	ra.resolvedURL = nil
+	if ra.localURLs && isLocalURL(ra.req.URL) {
+		err = ra.openLocal()
+		if err != nil {
+			return err
+		}
+		return ra.checkContentType()
+	}
+	if ra.prober != nil {
+		ok, err := ra.probe(ctx)
+		if err != nil || ok {
+			return err
+		}
+	}
+	// Make 1 byte (or WithProbeSize) Range Request to see if they are
+	// supported or not. Also stores the file metadata for later use.
+	probe := make([]byte, 1)
+	if ra.probeSize > 1 {
+		probe = make([]byte, ra.probeSize)
+	}
+	var info respInfo
+	n, err := ra.readAt(ctx, probe, 0, true, &info)
+	if (err == io.EOF || err == io.ErrUnexpectedEOF) && n > 0 && len(probe) > 1 {
+		// the file is smaller than the probe
+		err = nil
+	}
+	if err != nil && info.StatusCode != 0 {
+		return newProbeError(info, err)
+	}
+	if err == nil && !ra.usebs && !info.encoded {
+		ra.probeData = probe[:n]
+	}
+	return err
+}
+
+// readProbeData serves a read fully within the data received by the probe.
+// It reports false if the read needs to be made from the server.
+func (ra *HTTPReaderAt) readProbeData(p []byte, off int64) (n int, ok bool) {
+	if ra.revalidate || off < 0 || off > int64(len(ra.probeData))-int64(len(p)) {
+		return 0, false
+	}
+	return copy(p, ra.probeData[off:]), true
+}
+
+// Reset makes the HTTPReaderAt access a different remote file specified
+// by the prototype request req, retaining the client, the Store and the
+// options. The cached metadata and block cache are cleared and the new
+// file is probed as in New. A Store created by the HTTPReaderAt itself is
+// closed. Reset must not be called concurrently with any other method.
+func (ra *HTTPReaderAt) Reset(req *http.Request) error {
+	if req.Method != "GET" {
+		return errInvalidMethod
+	}
+	err := ra.Close()
+	if err != nil {
+		return err
+	}
+	if ra.ownbs {
+		ra.bs = nil
+		ra.ownbs = false
+	}
+	ra.usebs = false
+	atomic.StoreInt32(&ra.autoBuffered, 0)
+	ra.autoBufferMu.Lock()
+	ra.autoBufferTried = false
+	ra.autoBufferMu.Unlock()
+	ra.req = req
+	ra.header = cloneHeader(req.Header)
+	ra.meta = Meta{}
+	if ra.cache != nil {
+		ra.cache = newBlockCache(ra.cache.blockSize, ra.cache.maxBlocks)
+	}
+	return ra.init(context.Background())
+}
+
+// Close closes the Store created by the StoreFactory given to
+// NewWithStoreFactory, if any, as well as any local file opened because of
+// WithLocalURLs. A Store given to New is not closed; it remains the
+// responsibility of the caller.
+func (ra *HTTPReaderAt) Close() error {
+	if !ra.ownbs || ra.bs == nil {
+		return nil
+	}
+	atomic.StoreInt32(&ra.autoBuffered, 0)
+	err := ra.bs.Close()
+	ra.bs = nil
+	ra.usebs = false
+	return err
+}
+
+// Clone returns a new HTTPReaderAt accessing the same remote file without
+// probing it again. The clone shares the client, the options, the Store
+// and the block cache with ra, but it has its own copy of the metadata,
+// its own Stats and its own read batching. WithAutoBufferThreshold is not
+// applied to the clone. A Store owned by ra is not closed by calling Close
+// on the clone, so ra must not be Closed while the clone is in use. Clone must not be called concurrently with Reset.
+func (ra *HTTPReaderAt) Clone() *HTTPReaderAt {
+	ra.reqMu.RLock()
+	req, header := ra.req, ra.header
+	ra.reqMu.RUnlock()
+
+	c := &HTTPReaderAt{
+		client: ra.client,
+		req:    req,
+		meta:   ra.Meta(),
+		bs:     ra.bs,
+		bsf:    ra.bsf,
+		usebs:  ra.usebs,
+		header: header,
+
+		captureCookies:      ra.captureCookies,
+		probeOpenEnded:      ra.probeOpenEnded,
+		liveSize:            ra.liveSize,
+		localURLs:           ra.localURLs,
+		preBufferCheck:      ra.preBufferCheck,
+		expectedType:        ra.expectedType,
+		autoStore:           ra.autoStore,
+		strictProbe:         ra.strictProbe,
+		diskSpaceCheck:      ra.diskSpaceCheck,
+		revalidate:          ra.revalidate,
+		strictEOF:           ra.strictEOF,
+		resumableReads:      ra.resumableReads,
+		refreshSizeOnEOF:    ra.refreshSizeOnEOF,
+		strictBodyLength:    ra.strictBodyLength,
+		etagOptional:        ra.etagOptional,
+		checkTotal:          ra.checkTotal,
+		strictContentLength: ra.strictContentLength,
+		disableFallback:     ra.disableFallback,
+		followPermanent:     ra.followPermanent,
+		requireKnownSize:    ra.requireKnownSize,
+
+		cancelBuffering:    ra.cancelBuffering,
+		prober:             ra.prober,
+		opTimeout:          ra.opTimeout,
+		probeEncoding:      ra.probeEncoding,
+		dataEncoding:       ra.dataEncoding,
+		tracer:             ra.tracer,
+		rangeEncoder:       ra.rangeEncoder,
+		observer:           ra.observer,
+		checkRedirect:      ra.checkRedirect,
+		urlRefresher:       ra.urlRefresher,
+		retryAttempts:      ra.retryAttempts,
+		retryDelay:         ra.retryDelay,
+		retryPredicate:     ra.retryPredicate,
+		backoff:            ra.backoff,
+		validationInterval: ra.validationInterval,
+		fallbackDecider:    ra.fallbackDecider,
+		bufPool:            ra.bufPool,
+		rangeHeader:        ra.rangeHeader,
+		probeSize:          ra.probeSize,
+
+		probeData:   ra.probeData,
+		resolvedURL: ra.resolvedURL,
+		cache:       ra.cache,
+		sem:         ra.sem,
+		counters:    &counters{},
+	}
+	if ra.batcher != nil {
+		c.batcher = &readBatcher{
+			ra:     c,
+			window: ra.batcher.window,
+			maxGap: ra.batcher.maxGap,
+		}
+	}
+	return c
+}
+
+// Meta returns a consistent snapshot of the metadata of the remote file.
+func (ra *HTTPReaderAt) Meta() Meta {
+	ra.metaMu.RLock()
+	defer ra.metaMu.RUnlock()
+	return ra.meta
+}
+
 // ContentType returns "Content-Type" header contents.
 func (ra *HTTPReaderAt) ContentType() string {
-	return ra.meta.contentType
+	return ra.Meta().ContentType
+}
+
+// ResolvedURL returns the URL which served the probe made by New, that is
+// the final URL after following redirects, for example to a mirror or to
+// a presigned URL. It returns the URL of the prototype request if there
+// were no redirects, and nil for an HTTPReaderAt created with
+// NewFromReaderAt. Note that the URL may contain credentials.
+func (ra *HTTPReaderAt) ResolvedURL() *url.URL {
+	u := ra.resolvedURL
+	if u == nil {
+		ra.reqMu.RLock()
+		if ra.req != nil {
+			u = ra.req.URL
+		}
+		ra.reqMu.RUnlock()
+	}
+	if u == nil {
+		return nil
+	}
+	out := *u
+	return &out
 }
 
 // LastModified returns "Last-Modified" header contents.
 func (ra *HTTPReaderAt) LastModified() string {
-	return ra.meta.lastModified
+	return ra.Meta().LastModified
 }
 
 // Size returns the size of the file.
 func (ra *HTTPReaderAt) Size() int64 {
-	return ra.meta.size
+	ra.metaMu.RLock()
+	defer ra.metaMu.RUnlock()
+	return ra.meta.Size
+}
+
+// Remaining returns the number of bytes from byte offset off to the end of
+// the file, or 0 if off is at or beyond the end. It returns -1 if the size
+// of the file is unknown. With WithLiveSize or WithRefreshSizeOnEOF the
+// result is based on the size as currently known.
+func (ra *HTTPReaderAt) Remaining(off int64) int64 {
+	size := ra.Size()
+	if size == -1 {
+		return -1
+	}
+	if off >= size {
+		return 0
+	}
+	if off < 0 {
+		off = 0
+	}
+	return size - off
+}
+
+// Partitions divides the remote file into at most n contiguous sections of
+// as equal size as possible, for example for processing the file in
+// parallel. If the size of the file is not divisible by n, the first
+// sections are one byte longer than the rest. Fewer than n sections are
+// returned if the file is smaller than n bytes, so that no section is
+// empty. Nil is returned if n is not positive, if the file is empty or if
+// its size is unknown.
+func (ra *HTTPReaderAt) Partitions(n int) []*io.SectionReader {
+	size := ra.Size()
+	if n <= 0 || size <= 0 {
+		return nil
+	}
+	if int64(n) > size {
+		n = int(size)
+	}
+	parts := make([]*io.SectionReader, n)
+	base, extra := size/int64(n), size%int64(n)
+	var off int64
+	for i := range parts {
+		l := base
+		if int64(i) < extra {
+			l++
+		}
+		parts[i] = io.NewSectionReader(ra, off, l)
+		off += l
+	}
+	return parts
 }
 
 // ReadAt reads len(b) bytes from the remote file starting at byte offset
@@ -90,10 +573,209 @@ func (ra *HTTPReaderAt) Size() int64 {
 // Content-Type, Last-Modified and ETag headers between consecutive ReadAt
 // calls. In case any change is detected, ErrValidationFailed is returned.
 func (ra *HTTPReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
-	return ra.readAt(p, off, false)
+	return ra.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is like ReadAt, but the HTTP requests are made with the
+// context ctx. If ctx is canceled, including while waiting for a free
+// slot because of WithMaxConcurrency, the error of ctx is returned.
+func (ra *HTTPReaderAt) ReadAtContext(ctx context.Context, p []byte, off int64) (n int, err error) {
+	ctx, cancel := ra.operationContext(ctx)
+	defer cancel()
+
+	atomic.AddInt64(&ra.counters.readAtCalls, 1)
+
+	ctx, endSpan := ra.startSpan(ctx, SpanReadAt)
+	defer func() {
+		endSpan(SpanInfo{Off: off, Len: int64(len(p)), Bytes: n}, err)
+		ra.observe(off, p[:n], err)
+		ra.maybeAutoBuffer(ctx)
+	}()
+
+	if atomic.LoadInt32(&ra.autoBuffered) == 1 {
+		return ra.bs.ReadAt(p, off)
+	}
+	if n, ok := ra.readProbeData(p, off); ok {
+		return n, nil
+	}
+
+	if ra.cache != nil && !ra.usebs {
+		return ra.readAtCached(ctx, p, off)
+	}
+	if ra.batcher != nil && !ra.usebs {
+		return ra.batcher.readAt(ctx, p, off)
+	}
+	return ra.readAt(ctx, p, off, false, nil)
+}
+
+// Flush immediately issues the ReadAt calls which are waiting to be
+// batched because of WithReadBatching. It does nothing if read batching
+// is not enabled.
+func (ra *HTTPReaderAt) Flush() {
+	if ra.batcher != nil {
+		ra.batcher.flush()
+	}
+}
+
+// ReadAtResp is like ReadAt, but it also returns the header of the HTTP
+// response which served the requested range. This can be used for
+// accessing provider specific response headers. The returned header is
+// nil if no HTTP request was made, for example if the data was served
+// from the Store or if len(p) == 0.
+func (ra *HTTPReaderAt) ReadAtResp(p []byte, off int64) (n int, hdr http.Header, err error) {
+	ctx, cancel := ra.operationContext(context.Background())
+	defer cancel()
+
+	atomic.AddInt64(&ra.counters.readAtCalls, 1)
+	var info respInfo
+	n, err = ra.readAt(ctx, p, off, false, &info)
+	ra.observe(off, p[:n], err)
+	return n, info.Header, err
+}
+
+// observe calls the observer given with WithReadObserver if the read
+// delivered data successfully.
+func (ra *HTTPReaderAt) observe(off int64, p []byte, err error) {
+	if ra.observer != nil && len(p) > 0 && (err == nil || err == io.EOF) {
+		ra.observer(off, p)
+	}
+}
+
+// warmUpChunkSize is the size of the range requests made by WarmUp.
+const warmUpChunkSize = 1024 * 1024
+
+// WarmUp reads the whole remote file sequentially and discards the data.
+// It is meant for populating caches upstream of HTTPReaderAt, such as
+// a caching proxy or a CDN. Unlike Prefetch, the data is not retained in
+// the block cache. It returns the number of bytes read, which is less than
+// Size only if an error occurred. Cancelling ctx aborts WarmUp. Nothing is
+// read if the file is already buffered to the Store.
+func (ra *HTTPReaderAt) WarmUp(ctx context.Context) (n int64, err error) {
+	if ra.usebs {
+		return 0, nil
+	}
+	buf := ra.getBuf(warmUpChunkSize)
+	defer ra.putBuf(buf)
+	for {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		m, err := ra.readAt(ctx, buf, n, false, nil)
+		n += int64(m)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		if size := ra.Size(); size != -1 && n >= size {
+			return n, nil
+		}
+	}
+}
+
+var errNegativeOffset = errors.New("negative offset")
+
+var errRangeOverflow = errors.New("byte range overflows int64")
+
+const maxInt = int64(^uint(0) >> 1)
+
+// rangeEnd returns off+n, the exclusive end of the byte range of length n
+// starting at off. An error is returned if off is negative or if the end
+// overflows int64.
+func rangeEnd(off, n int64) (int64, error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	if n < 0 || off > math.MaxInt64-n {
+		return 0, errRangeOverflow
+	}
+	return off + n, nil
+}
+
+// operationContext returns the context for a single ReadAt call derived
+// from ctx. It has a deadline if WithOperationDeadline is set.
+func (ra *HTTPReaderAt) operationContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ra.opTimeout > 0 {
+		return context.WithTimeout(ctx, ra.opTimeout)
+	}
+	return ctx, func() {}
+}
+
+// maxResumeAttempts is the maximum number of requests made for resuming
+// a truncated response with WithResumableReads.
+const maxResumeAttempts = 3
+
+// truncatedError is returned by readRange if WithResumableReads is enabled
+// and the body of a response ended before the whole range was received.
+type truncatedError struct {
+	err error
+}
+
+func (e *truncatedError) Error() string {
+	return e.err.Error()
+}
+
+// readAt reads the byte range from the server. If WithResumableReads is
+// enabled and the response is truncated, the missing tail of the range
+// is requested again.
+func (ra *HTTPReaderAt) readAt(ctx context.Context, p []byte, off int64, initialize bool, info *respInfo) (n int, err error) {
+	n, err = ra.readRange(ctx, p, off, initialize, info)
+	if !initialize && ra.urlRefresher != nil && isURLExpired(err) {
+		err = ra.refreshURL(ctx)
+		if err != nil {
+			return 0, err
+		}
+		n, err = ra.readRange(ctx, p, off, initialize, info)
+	}
+	for attempt := 0; attempt < maxResumeAttempts; attempt++ {
+		if _, ok := err.(*truncatedError); !ok {
+			break
+		}
+		var m int
+		m, err = ra.readRange(ctx, p[n:], off+int64(n), false, nil)
+		n += m
+	}
+	if te, ok := err.(*truncatedError); ok {
+		err = te.err
+		if err == io.ErrUnexpectedEOF && !ra.strictEOF {
+			err = io.EOF
+		}
+	}
+	return n, err
+}
+
+// isURLExpired reports whether err indicates that the URL of the request
+// has expired, as happens with presigned URLs.
+func isURLExpired(err error) bool {
+	he, ok := err.(*HTTPError)
+	return ok && he.StatusCode == http.StatusForbidden
+}
+
+// refreshURL replaces the prototype request with the one returned by the
+// function given with WithURLRefresher.
+func (ra *HTTPReaderAt) refreshURL(ctx context.Context) error {
+	req, err := ra.urlRefresher(ctx)
+	if err != nil {
+		return errors.Wrap(err, "url refresh error")
+	}
+	if req.Method != "GET" {
+		return errInvalidMethod
+	}
+	header := cloneHeader(req.Header)
+
+	ra.reqMu.Lock()
+	ra.req = req
+	ra.header = header
+	ra.reqMu.Unlock()
+	return nil
 }
 
-func (ra *HTTPReaderAt) readAt(p []byte, off int64, initialize bool) (n int, err error) {
+func (ra *HTTPReaderAt) readRange(ctx context.Context, p []byte, off int64, initialize bool, info *respInfo) (n int, err error) {
+	end, err := rangeEnd(off, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
 	if ra.usebs == true {
 		return ra.bs.ReadAt(p, off)
 	}
@@ -101,16 +783,30 @@ func (ra *HTTPReaderAt) readAt(p []byte, off int64, initialize bool) (n int, err
 	if len(p) == 0 {
 		return 0, nil
 	}
-	req := ra.copyReq()
+	var span SpanInfo
+	ctx, endSpan := ra.startSpan(ctx, SpanRequest)
+	defer func() {
+		span.Bytes = n
+		endSpan(span, err)
+	}()
+
+	req := ra.copyReq().WithContext(ctx)
 
 	reqFirst := off
-	reqLast := off + int64(len(p)) - 1
+	reqLast := end - 1
 
 	var returnErr error
-	if !initialize && ra.meta.size != -1 && reqLast > ra.meta.size-1 {
+	size := ra.Size()
+	if !initialize && size != -1 && reqLast > size-1 && ra.refreshSizeOnEOF {
+		size, err = ra.refreshSize(ctx)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if !initialize && size != -1 && reqLast > size-1 {
 		// Clamp down the requested range because some servers return
 		// "416 Range Not Satisfiable" if trying to read past the end of the file.
-		reqLast = ra.meta.size - 1
+		reqLast = size - 1
 		returnErr = io.EOF
 		if reqLast < reqFirst {
 			return 0, io.EOF
@@ -118,86 +814,411 @@ func (ra *HTTPReaderAt) readAt(p []byte, off int64, initialize bool) (n int, err
 		p = p[:reqLast-reqFirst+1]
 	}
 
-	reqRange := fmt.Sprintf("bytes=%d-%d", reqFirst, reqLast)
-	req.Header.Set("Range", reqRange)
+	openEnded := initialize && ra.probeOpenEnded && ra.rangeEncoder == nil
+	if ra.rangeEncoder != nil {
+		ra.rangeEncoder(req, reqFirst, reqLast)
+	} else {
+		reqRange := fmt.Sprintf("bytes=%d-%d", reqFirst, reqLast)
+		if openEnded {
+			reqRange = fmt.Sprintf("bytes=%d-", reqFirst)
+		}
+		req.Header.Set(ra.rangeHeaderName(), reqRange)
+	}
+	span.Off, span.Len = reqFirst, reqLast-reqFirst+1
+	if initialize && ra.probeEncoding != "" {
+		req.Header.Set("Accept-Encoding", ra.probeEncoding)
+	} else if !initialize && ra.dataEncoding != "" {
+		req.Header.Set("Accept-Encoding", ra.dataEncoding)
+	}
+	requestIdentity(req)
+	ifRange := false
+	if !initialize && ra.revalidate {
+		if v := ra.ifRangeValue(); v != "" {
+			req.Header.Set("If-Range", v)
+			ifRange = true
+		}
+	}
 
-	resp, err := ra.client.Do(req)
+	if ra.sem != nil {
+		select {
+		case ra.sem <- struct{}{}:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+		defer func() { <-ra.sem }()
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+	atomic.AddInt64(&ra.counters.networkReads, 1)
+	resp, err := ra.do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		if _, ok := err.(*RedirectError); ok {
+			return 0, err
+		}
 		return 0, errors.Wrap(err, "http request error")
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return 0, errors.Errorf("http request error: %s", resp.Status)
+	span.StatusCode = resp.StatusCode
+	if info != nil {
+		*info = respInfo{resp.StatusCode, resp.Status, resp.Header, isContentEncoded(resp)}
 	}
+
+	var first, last int64
 	if initialize {
-		ra.meta = getMeta(resp)
+		first, last, err = ra.initResponse(resp, reqFirst, reqLast, openEnded)
 	} else {
-		err = ra.validate(resp)
-		if err != nil {
-			return 0, err
-		}
+		first, last, err = ra.checkResponse(resp, reqFirst, reqLast, ifRange)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if ra.usebs {
+		// the probe response was buffered in the Store
+		return ra.bs.ReadAt(p, off)
+	}
+	if openEnded && first == reqFirst {
+		// Only the beginning of the body is read. The rest of it is
+		// not downloaded because the body is closed early.
+		n, err = io.ReadFull(resp.Body, p)
+		atomic.AddInt64(&ra.counters.bytesFetched, int64(n))
+		return n, err
+	}
+	// Content-Range is the authority on the body length. Content-Length
+	// is only advisory because some servers and proxies omit it (it is
+	// -1 with chunked transfer-encoding) or set it incorrectly.
+	want := last - first + 1
+	n, err = io.ReadFull(resp.Body, p[:want])
+	atomic.AddInt64(&ra.counters.bytesFetched, int64(n))
+	if err == nil && drainBody(resp.Body) && ra.strictBodyLength {
+		return n, ErrBodyTooLong
+	}
+	if err == nil && int64(len(p)) > want {
+		// the server returned a shorter range than requested
+		err = io.EOF
+	}
+
+	if err != nil && ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	if err != nil && int64(n) < want && ra.resumableReads && !initialize {
+		return n, &truncatedError{err}
+	}
+	if err == io.ErrUnexpectedEOF && !ra.strictEOF {
+		err = io.EOF
+	}
+	if (err == nil || err == io.EOF) && resp.ContentLength != -1 &&
+		(int64(n) != resp.ContentLength || want != resp.ContentLength) &&
+		ra.strictContentLength {
+		// By default Content-Range is trusted (see above).
+		return n, ErrContentLengthMismatch
+	}
+	if err == nil && returnErr != nil {
+		err = returnErr
+	}
+	return n, err
+}
+
+// initResponse handles the response to the probe made by New: it stores
+// the metadata of the file and either buffers the response in the Store as
+// a fallback or returns the received range.
+func (ra *HTTPReaderAt) initResponse(resp *http.Response, reqFirst, reqLast int64, openEnded bool) (first, last int64, err error) {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, newHTTPError(resp)
+	}
+	// With a custom range encoder the server may respond with just the
+	// requested bytes without a Content-Range header.
+	encodedRange := ra.rangeEncoder != nil && resp.Header.Get("Content-Range") == ""
+	ra.resolvedURL = resp.Request.URL
+	ra.meta = ra.respMeta(resp)
+	atomic.StoreInt64(&ra.counters.lastValidated, time.Now().UnixNano())
+	err = ra.checkContentType()
+	if err != nil {
+		return 0, 0, err
 	}
-	if resp.StatusCode == http.StatusOK {
-		if ra.bs == nil {
-			return 0, ErrNoRange
+	if ra.captureCookies && ra.client.Jar == nil {
+		ra.addCookies(resp.Cookies())
+	}
+	fallback := resp.StatusCode == http.StatusOK && !encodedRange
+	if ra.fallbackDecider != nil {
+		decided := ra.fallbackDecider(resp)
+		if fallback && !decided {
+			return 0, 0, &NoRangeError{Meta: ra.meta}
 		}
-		if !initialize {
-			return 0, errors.New("server suddenly stopped supporting range requests")
+		if decided && resp.StatusCode == http.StatusPartialContent {
+			// only a range covering the whole file can be buffered
+			first, last, length, err := parseContentRange(resp.Header.Get("Content-Range"))
+			if err != nil || first != 0 || length == -1 || last != length-1 {
+				return 0, 0, errors.New("partial response chosen for buffering does not cover the whole file")
+			}
 		}
-		// The following code path is not thread safe.
-		// We end up here only from New
-		// (initialize == true) and at that point concurrency
-		// is not possible.
+		fallback = decided
+	}
+	if fallback {
+		return 0, 0, ra.bufferResponse(resp)
+	}
 
-		ra.usebs = true
-		size, err := ra.bs.ReadFrom(resp.Body)
-		if resp.ContentLength != -1 && resp.ContentLength != size {
-			// meta size does not match body size, should we care? XXX
+	first, last, length := reqFirst, reqLast, int64(-1)
+	if !encodedRange {
+		contentRange := resp.Header.Get("Content-Range")
+		if contentRange == "" {
+			return 0, 0, errors.New("no content-range header in partial response")
+		}
+		first, last, length, err = parseContentRange(contentRange)
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "http request error")
 		}
-		if resp.ContentLength == -1 {
-			ra.meta.size = size
+	}
+	if ra.strictProbe && !encodedRange &&
+		(first != reqFirst || (last != reqLast && !openEnded) ||
+			(length != -1 && length <= last)) {
+		return 0, 0, ErrInconsistentProbe
+	}
+	if openEnded && first == reqFirst {
+		// The open ended range extends to the end of the file.
+		if length == -1 {
+			ra.meta.Size = last + 1
 		}
+		return first, last, nil
+	}
+	return first, last, checkReceivedRange(reqFirst, reqLast, first, last)
+}
 
+// requestIdentityBody requests the whole file again without
+// content-encoding for buffering it instead of the encoded response resp.
+// The metadata of the file is updated from the new response.
+func (ra *HTTPReaderAt) requestIdentityBody(resp *http.Response) (*http.Response, error) {
+	req := ra.copyReq().WithContext(resp.Request.Context())
+	req.Header.Set("Accept-Encoding", "identity")
+	atomic.AddInt64(&ra.counters.networkReads, 1)
+	identity, err := ra.do(req)
+	if err != nil {
+		if _, ok := err.(*RedirectError); ok {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "http request error")
+	}
+	if identity.StatusCode != http.StatusOK {
+		identity.Body.Close()
+		return nil, newHTTPError(identity)
+	}
+	if isContentEncoded(identity) {
+		identity.Body.Close()
+		return nil, errors.New("response chosen for buffering has content-encoding")
+	}
+	// The ETag is not compared as servers commonly use a different one
+	// for each encoding of the file.
+	m := ra.respMeta(identity)
+	if m.LastModified != ra.meta.LastModified {
+		identity.Body.Close()
+		return nil, ErrValidationFailed
+	}
+	ra.meta = m
+	return identity, nil
+}
+
+// bufferResponse reads the whole file from the body of resp to the Store
+// as a fallback for a server which does not support Range Requests.
+func (ra *HTTPReaderAt) bufferResponse(resp *http.Response) error {
+	if (ra.bs == nil && ra.bsf == nil) || ra.disableFallback {
+		return &NoRangeError{Meta: ra.meta}
+	}
+	if ce := resp.Header.Get("Content-Encoding"); ce != "" && !strings.EqualFold(ce, "identity") {
+		// The compressed body (for example because of a probe
+		// encoding set with WithAcceptEncoding) is not the file.
+		identity, err := ra.requestIdentityBody(resp)
 		if err != nil {
-			return 0, err
+			return err
 		}
-		return ra.bs.ReadAt(p, off)
+		defer identity.Body.Close()
+		resp = identity
+	}
+	if ra.preBufferCheck != nil {
+		err := ra.preBufferCheck(ra.meta)
+		if err != nil {
+			return err
+		}
+	}
+	if ra.bs == nil {
+		ra.bs = ra.bsf()
+		ra.ownbs = true
+	}
+	err := ra.checkDiskSpace()
+	if err != nil {
+		return err
 	}
+	// The following code path is not thread safe.
+	// We end up here only from New and at that point concurrency
+	// is not possible.
 
+	size, err := ra.fillStore(resp.Body)
+	atomic.AddInt64(&ra.counters.bytesFetched, size)
+	if err != nil {
+		// A partially filled Store is never used: the error is
+		// returned as is (so that for example ErrStoreLimit can be
+		// compared against) and New fails.
+		return err
+	}
+	if resp.ContentLength != -1 && resp.ContentLength != size &&
+		ra.strictContentLength {
+		return ErrContentLengthMismatch
+	}
+	ra.usebs = true
+	if resp.ContentLength == -1 {
+		ra.meta.Size = size
+	}
+	return nil
+}
+
+// checkResponse validates the response to a Range Request for bytes
+// reqFirst...reqLast (inclusive, reqLast is negative for an open ended
+// range) made after New and returns the received range. It is used for
+// both ReadAt and the streaming reads, so that every response is checked
+// in the same way: the metadata is validated as configured, the total
+// length in the Content-Range header is compared to the cached size (or
+// updates it with WithLiveSize) and the received range must be the
+// requested one. ifRange tells that the request had an If-Range header.
+func (ra *HTTPReaderAt) checkResponse(resp *http.Response, reqFirst, reqLast int64, ifRange bool) (first, last int64, err error) {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return 0, 0, newHTTPError(resp)
+	}
+	encodedRange := ra.rangeEncoder != nil && resp.Header.Get("Content-Range") == ""
+	err = ra.validate(resp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode == http.StatusOK && !encodedRange {
+		if ifRange {
+			// The If-Range condition failed: the file has changed.
+			return 0, 0, ErrValidationFailed
+		}
+		return 0, 0, ErrRangeSupportLost
+	}
+	if resp.StatusCode == http.StatusPartialContent && isContentEncoded(resp) {
+		return 0, 0, ErrRangeEncoded
+	}
+	if encodedRange {
+		return reqFirst, reqLast, nil
+	}
 	contentRange := resp.Header.Get("Content-Range")
 	if contentRange == "" {
-		return 0, errors.New("no content-range header in partial response")
+		return 0, 0, errors.New("no content-range header in partial response")
 	}
-	first, last, _, err := parseContentRange(contentRange)
+	first, last, length, err := parseContentRange(contentRange)
 	if err != nil {
-		return 0, errors.Wrap(err, "http request error")
+		return 0, 0, errors.Wrap(err, "http request error")
 	}
-	if first != reqFirst || last > reqLast {
-		return 0, errors.Errorf(
+	if ra.checkTotal {
+		err = ra.checkTotalLength(length)
+	} else if length != -1 {
+		// Cross-check the total length against the cached size.
+		err = ra.checkSize(length)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return first, last, checkReceivedRange(reqFirst, reqLast, first, last)
+}
+
+// checkReceivedRange checks that the received range first...last is the
+// requested range reqFirst...reqLast or its beginning.
+func checkReceivedRange(reqFirst, reqLast, first, last int64) error {
+	if first != reqFirst || (reqLast >= 0 && last > reqLast) || last < first {
+		return errors.Errorf(
 			"received different range than requested (req=%d-%d, resp=%d-%d)",
 			reqFirst, reqLast, first, last)
 	}
-	if resp.ContentLength != last-first+1 {
-		return 0, errors.New("content-length mismatch in http response")
+	return nil
+}
+
+// isContentEncoded reports whether resp has a "Content-Encoding" header
+// other than "identity" or its body was transparently decompressed by
+// http.Transport.
+func isContentEncoded(resp *http.Response) bool {
+	ce := resp.Header.Get("Content-Encoding")
+	return resp.Uncompressed || ce != "" && !strings.EqualFold(ce, "identity")
+}
+
+// requestIdentity requests an unencoded response if req has neither a
+// "Range" nor an "Accept-Encoding" header. http.Transport would otherwise
+// request gzip and decompress the body transparently, removing the
+// "Content-Encoding" header, which happens with a custom range header
+// name or a range encoder.
+func requestIdentity(req *http.Request) {
+	if req.Header.Get("Range") == "" && req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "identity")
 	}
-	n, err = io.ReadFull(resp.Body, p)
+}
 
-	if err == io.ErrUnexpectedEOF {
-		err = io.EOF
+// maxDrain is the maximum number of bytes read and discarded from the end
+// of a response body in order to allow reusing the connection.
+const maxDrain = 4096
+
+// drainBody reads and discards what remains of body, up to maxDrain bytes,
+// so that the HTTP keep-alive connection can be reused. It reports whether
+// there were any bytes left.
+func drainBody(body io.Reader) (extra bool) {
+	n, _ := io.CopyN(ioutil.Discard, body, maxDrain)
+	return n > 0
+}
+
+// respInfo is the status and the header of the HTTP response which
+// served a range, as reported by readAt.
+type respInfo struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	encoded    bool // the body is content encoded, see isContentEncoded
+}
+
+// ifRangeValue returns the value of the If-Range header used with
+// WithRevalidate: the ETag if it is a strong one, otherwise Last-Modified.
+func (ra *HTTPReaderAt) ifRangeValue() string {
+	m := ra.Meta()
+	if m.ETag != "" && !strings.HasPrefix(m.ETag, "W/") {
+		return m.ETag
 	}
-	if (err == nil || err == io.EOF) && int64(n) != resp.ContentLength {
-		// XXX body size was different from the ContentLength
-		// header? should we do something about it? return error?
+	return m.LastModified
+}
+
+// fillStore reads body to the Store. If the channel given with
+// WithCancelBuffering is closed during the download, the body is closed
+// to abort the download, the Store is closed to free the partially
+// buffered data and ErrBufferingCanceled is returned.
+func (ra *HTTPReaderAt) fillStore(body io.ReadCloser) (size int64, err error) {
+	if ra.cancelBuffering == nil {
+		return ra.bs.ReadFrom(body)
 	}
-	if err == nil && returnErr != nil {
-		err = returnErr
+	done := make(chan struct{})
+	canceled := make(chan struct{})
+	go func() {
+		select {
+		case <-ra.cancelBuffering:
+			close(canceled)
+			body.Close()
+		case <-done:
+		}
+	}()
+	size, err = ra.bs.ReadFrom(body)
+	close(done)
+
+	select {
+	case <-canceled:
+		ra.bs.Close()
+		return 0, ErrBufferingCanceled
+	default:
 	}
-	return n, err
+	return size, err
 }
 
 var errParse = errors.New("content-range parse error")
 
+var errProbeRange = errors.New("received different range than requested in probe")
+
 func parseContentRange(str string) (first, last, length int64, err error) {
 	first, last, length = -1, -1, -1
 
@@ -206,11 +1227,14 @@ func parseContentRange(str string) (first, last, length int64, err error) {
 	// Content-Range: bytes */1234
 	// (Maybe I should have used regexp here instead of Splitting... :)
 
-	strs := strings.Split(str, " ")
-	if len(strs) != 2 || strs[0] != "bytes" {
+	// Extra whitespace around the fields and the separators is tolerated
+	// because some servers (and test fixtures) send it.
+	str = strings.TrimSpace(str)
+	i := strings.IndexAny(str, " \t")
+	if i == -1 || str[:i] != "bytes" {
 		return -1, -1, -1, errParse
 	}
-	strs = strings.Split(strs[1], "/")
+	strs := splitTrim(str[i:], "/")
 	if len(strs) != 2 {
 		return -1, -1, -1, errParse
 	}
@@ -221,7 +1245,7 @@ func parseContentRange(str string) (first, last, length int64, err error) {
 		}
 	}
 	if strs[0] != "*" {
-		strs = strings.Split(strs[0], "-")
+		strs = splitTrim(strs[0], "-")
 		if len(strs) != 2 {
 			return -1, -1, -1, errParse
 		}
@@ -240,6 +1264,16 @@ func parseContentRange(str string) (first, last, length int64, err error) {
 	return first, last, length, nil
 }
 
+// splitTrim splits s around sep and trims the whitespace around each
+// of the parts.
+func splitTrim(s, sep string) []string {
+	strs := strings.Split(s, sep)
+	for i := range strs {
+		strs[i] = strings.TrimSpace(strs[i])
+	}
+	return strs
+}
+
 func cloneHeader(h http.Header) http.Header {
 	h2 := make(http.Header, len(h))
 	for k, vv := range h {
@@ -250,45 +1284,206 @@ func cloneHeader(h http.Header) http.Header {
 	return h2
 }
 
+// rangeHeaderName returns the name of the header used for the requested
+// range, "Range" unless changed with WithRangeHeaderName.
+func (ra *HTTPReaderAt) rangeHeaderName() string {
+	if ra.rangeHeader == "" {
+		return "Range"
+	}
+	return ra.rangeHeader
+}
+
+// copyReq copies the prototype request. The header map is copied, but
+// the value slices are shared with ra.header. This is safe because the
+// values are only ever replaced (with Header.Set), never modified in place.
+// A full deep copy would cost several allocations on every ReadAt.
 func (ra *HTTPReaderAt) copyReq() *http.Request {
+	ra.reqMu.RLock()
+	defer ra.reqMu.RUnlock()
+
 	out := *ra.req
+	u := *ra.req.URL
+	out.URL = &u
 	out.Body = nil
 	out.ContentLength = 0
-	out.Header = cloneHeader(ra.req.Header)
-
+	out.Header = make(http.Header, len(ra.header)+1)
+	for k, vv := range ra.header {
+		out.Header[k] = vv
+	}
 	return &out
 }
 
+// addCookies adds cookies to the base headers of the requests. It must
+// not be called concurrently with copyReq.
+func (ra *HTTPReaderAt) addCookies(cookies []*http.Cookie) {
+	req := &http.Request{Header: cloneHeader(ra.header)}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	ra.header = req.Header
+}
+
 func (ra *HTTPReaderAt) validate(resp *http.Response) (err error) {
-	m := getMeta(resp)
+	m := ra.respMeta(resp)
+	if ra.refreshSizeOnEOF {
+		return ra.validateGrowth(m)
+	}
+	var now int64
+	if ra.validationInterval > 0 {
+		now = time.Now().UnixNano()
+		last := atomic.LoadInt64(&ra.counters.lastValidated)
+		if now-last < int64(ra.validationInterval) {
+			return nil
+		}
+		defer func() {
+			if err == nil {
+				atomic.StoreInt64(&ra.counters.lastValidated, now)
+			}
+		}()
+	}
+
+	ra.metaMu.RLock()
+	defer ra.metaMu.RUnlock()
+
+	// an unknown total length ("bytes 0-99/*") is not a change, servers
+	// may only reveal the size in some responses (see
+	// UnsatisfiableRangeProber)
+	if (!ra.liveSize && m.Size != -1 && ra.meta.Size != m.Size) ||
+		ra.meta.LastModified != m.LastModified {
+		return ErrValidationFailed
+	}
+	if ra.meta.ETag != m.ETag && !(ra.etagOptional &&
+		m.LastModified != "" && ra.meta.Size == m.Size) {
+		return ErrValidationFailed
+	}
+	return nil
+}
 
-	if ra.meta.size != m.size ||
-		ra.meta.lastModified != m.lastModified ||
-		ra.meta.etag != m.etag {
+// validateGrowth validates the metadata m of a response allowing the file
+// to grow as with WithRefreshSizeOnEOF. If it has grown, the cached size
+// and Last-Modified are updated.
+func (ra *HTTPReaderAt) validateGrowth(m Meta) error {
+	ra.metaMu.Lock()
+	defer ra.metaMu.Unlock()
+
+	if ra.meta.ETag != m.ETag {
+		return ErrValidationFailed
+	}
+	if m.Size == ra.meta.Size {
+		if ra.meta.LastModified != m.LastModified {
+			return ErrValidationFailed
+		}
+		return nil
+	}
+	if m.Size < ra.meta.Size && !ra.liveSize {
 		return ErrValidationFailed
 	}
+	ra.meta.Size = m.Size
+	ra.meta.LastModified = m.LastModified
 	return nil
 }
 
-type meta struct {
-	size         int64
-	lastModified string
-	etag         string
-	contentType  string
+// refreshSize checks if the remote file has grown as with
+// WithRefreshSizeOnEOF by requesting the last byte of the file as
+// currently known. It returns the new size.
+func (ra *HTTPReaderAt) refreshSize(ctx context.Context) (int64, error) {
+	size := ra.Size()
+	if size <= 0 {
+		return size, nil
+	}
+	_, err := ra.readRange(ctx, make([]byte, 1), size-1, false, nil)
+	if err != nil && err != io.EOF {
+		return size, err
+	}
+	newSize := ra.Size()
+	if newSize > size && ra.cache != nil {
+		// the last block was cut short by the previous end of the file
+		ra.cache.remove((size - 1) / ra.cache.blockSize)
+	}
+	return newSize, nil
+}
+
+// checkContentType checks that the Content-Type of the remote file matches
+// the one given with WithExpectedContentType.
+func (ra *HTTPReaderAt) checkContentType() error {
+	if ra.expectedType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(ra.meta.ContentType)
+	if err != nil {
+		return ErrUnexpectedContentType
+	}
+	expected := strings.ToLower(ra.expectedType)
+	if strings.HasSuffix(expected, "/*") {
+		if strings.HasPrefix(mediaType, expected[:len(expected)-1]) {
+			return nil
+		}
+	} else if mediaType == expected {
+		return nil
+	}
+	return ErrUnexpectedContentType
+}
+
+// checkSize compares the total length reported by the server to the
+// cached size. If they differ, ErrValidationFailed is returned, unless
+// WithLiveSize is enabled, in which case the cached size is updated.
+func (ra *HTTPReaderAt) checkSize(length int64) error {
+	ra.metaMu.Lock()
+	defer ra.metaMu.Unlock()
+
+	if ra.meta.Size == length {
+		return nil
+	}
+	if !ra.liveSize {
+		return ErrValidationFailed
+	}
+	ra.meta.Size = length
+	return nil
+}
+
+// checkTotalLength checks the total length of a Content-Range header as
+// with WithCheckTotalLength. An unknown total length ("*") is not accepted.
+func (ra *HTTPReaderAt) checkTotalLength(length int64) error {
+	if length == -1 {
+		return ErrValidationFailed
+	}
+	return ra.checkSize(length)
+}
+
+// Meta contains the metadata of the remote file.
+type Meta struct {
+	Size         int64  // size of the file or -1 if unknown
+	LastModified string // "Last-Modified" header contents
+	ETag         string // "ETag" header contents
+	ContentType  string // "Content-Type" header contents
+	AcceptRanges bool   // true if the server supports Range Requests
+}
+
+// respMeta returns the metadata of the remote file from resp. If
+// WithRangeEncoder is used and the response does not have a Content-Range
+// header, the size of the file is unknown.
+func (ra *HTTPReaderAt) respMeta(resp *http.Response) Meta {
+	meta := getMeta(resp)
+	if ra.rangeEncoder != nil && resp.Header.Get("Content-Range") == "" {
+		meta.Size = -1
+		meta.AcceptRanges = true
+	}
+	return meta
 }
 
-func getMeta(resp *http.Response) (meta meta) {
-	meta.lastModified = resp.Header.Get("Last-Modified")
-	meta.etag = resp.Header.Get("ETag")
-	meta.contentType = resp.Header.Get("Content-Type")
+func getMeta(resp *http.Response) (meta Meta) {
+	meta.LastModified = resp.Header.Get("Last-Modified")
+	meta.ETag = resp.Header.Get("ETag")
+	meta.ContentType = resp.Header.Get("Content-Type")
 
 	switch resp.StatusCode {
 	case http.StatusOK:
-		meta.size = resp.ContentLength
+		meta.Size = resp.ContentLength
 	case http.StatusPartialContent:
+		meta.AcceptRanges = true
 		contentRange := resp.Header.Get("Content-Range")
 		if contentRange != "" {
-			_, _, meta.size, _ = parseContentRange(contentRange)
+			_, _, meta.Size, _ = parseContentRange(contentRange)
 		}
 	}
 	return meta
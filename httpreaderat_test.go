@@ -0,0 +1,1897 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testModTime is the modification time of the files served by the test
+// servers.
+var testModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// makeTestData returns size bytes of deterministic test data.
+func makeTestData(size int) []byte {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i*7 + i/251)
+	}
+	return data
+}
+
+// testServer is a httptest.Server serving a file with Range Request
+// support. It counts the requests made to it.
+type testServer struct {
+	*httptest.Server
+	requests int32
+}
+
+// newTestServer starts a testServer serving data with http.ServeContent.
+// If handler is not nil, it is called instead for serving the requests.
+func newTestServer(t testing.TB, data []byte, handler http.HandlerFunc) *testServer {
+	ts := &testServer{}
+	if handler == nil {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+		}
+	}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&ts.requests, 1)
+		handler(w, r)
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// Requests returns the number of requests made to the server so far.
+func (ts *testServer) Requests() int {
+	return int(atomic.LoadInt32(&ts.requests))
+}
+
+// rangeHandler serves data with support for single range Range Requests.
+// The fields allow making the responses deviate from a well behaving
+// server. The deviations are not applied to the first request, which is
+// the probe made by New.
+type rangeHandler struct {
+	requests int32
+	data     []byte
+	header   string // name of the range header, "Range" if empty
+	chunked  bool   // send the body with chunked transfer-encoding
+	total    string // total length in Content-Range, the size if empty
+	trunc    int    // number of bytes left out from the end of the body
+	extra    int    // number of extra bytes sent after the body
+}
+
+func (h *rangeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := h.header
+	if name == "" {
+		name = "Range"
+	}
+	size := int64(len(h.data))
+	w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+	first, last, ok := parseTestRange(r.Header.Get(name), size)
+	if !ok {
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		w.Write(h.data)
+		return
+	}
+	if first >= size {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+	if last >= size {
+		last = size - 1
+	}
+	total := h.total
+	if total == "" {
+		total = fmt.Sprint(size)
+	}
+	body := append([]byte{}, h.data[first:last+1]...)
+	chunked := false
+	if atomic.AddInt32(&h.requests, 1) > 1 {
+		body = body[:len(body)-h.trunc]
+		body = append(body, make([]byte, h.extra)...)
+		chunked = h.chunked
+	} else {
+		total = fmt.Sprint(size)
+	}
+
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%s", first, last, total))
+	if !chunked {
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+	}
+	w.WriteHeader(http.StatusPartialContent)
+	if !chunked {
+		w.Write(body)
+		return
+	}
+	for len(body) > 0 {
+		n := 7
+		if n > len(body) {
+			n = len(body)
+		}
+		w.Write(body[:n])
+		w.(http.Flusher).Flush()
+		body = body[n:]
+	}
+}
+
+// parseTestRange parses a single range "bytes=first-last" or "bytes=first-"
+// of a file of size bytes. It reports false if there is no valid range.
+func parseTestRange(s string, size int64) (first, last int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes=") {
+		return 0, 0, false
+	}
+	i := strings.IndexByte(s, '-')
+	if i == -1 || strings.Contains(s, ",") {
+		return 0, 0, false
+	}
+	first, err := strconv.ParseInt(s[len("bytes="):i], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	last = size - 1
+	if s[i+1:] != "" {
+		if last, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil {
+			return 0, 0, false
+		}
+	}
+	return first, last, first <= last || first >= size
+}
+
+// newTestReaderAt creates a HTTPReaderAt for url with the options opts.
+func newTestReaderAt(t testing.TB, url string, bs Store, opts ...Option) *HTTPReaderAt {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := New(nil, req, bs, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ra.Close() })
+	return ra
+}
+
+// readAndCheck reads n bytes at off from ra and checks them against data.
+func readAndCheck(t *testing.T, ra *HTTPReaderAt, data []byte, off int64, n int) {
+	t.Helper()
+	p := make([]byte, n)
+	got, err := ra.ReadAt(p, off)
+	if got != n || err != nil || !bytes.Equal(p, data[off:off+int64(n)]) {
+		t.Errorf("ReadAt(%d, %d) = %d, %v", off, n, got, err)
+	}
+}
+
+func TestReadAtEdgeCases(t *testing.T) {
+	data := makeTestData(100)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+
+	tests := []struct {
+		name    string
+		p       []byte
+		off     int64
+		wantN   int
+		wantErr error
+		request bool
+	}{
+		{"nil buffer", nil, 10, 0, nil, false},
+		{"zero length", []byte{}, 10, 0, nil, false},
+		{"zero length at end", []byte{}, 100, 0, nil, false},
+		{"zero length past end", []byte{}, 200, 0, nil, false},
+		{"past end", make([]byte, 10), 200, 0, io.EOF, false},
+		{"at end", make([]byte, 10), 100, 0, io.EOF, false},
+		{"across end", make([]byte, 10), 95, 5, io.EOF, true},
+		{"negative offset", make([]byte, 10), -1, 0, errNegativeOffset, false},
+		{"negative offset nil buffer", nil, -1, 0, errNegativeOffset, false},
+		{"whole file", make([]byte, 100), 0, 100, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := ts.Requests()
+			n, err := ra.ReadAt(tt.p, tt.off)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Fatalf("ReadAt = %d, %v; want %d, %v", n, err, tt.wantN, tt.wantErr)
+			}
+			if n > 0 && !bytes.Equal(tt.p[:n], data[tt.off:tt.off+int64(n)]) {
+				t.Error("ReadAt returned wrong data")
+			}
+			if made := ts.Requests() > before; made != tt.request {
+				t.Errorf("request made = %v; want %v", made, tt.request)
+			}
+		})
+	}
+}
+
+// newHeaderTestReaderAt creates a HTTPReaderAt for url with a prototype
+// request which has a few headers, as requests typically do.
+func newHeaderTestReaderAt(b *testing.B, url string) *HTTPReaderAt {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req.Header.Set("User-Agent", "httpreaderat-test")
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Request-Id", "1234")
+	ra, err := New(nil, req, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { ra.Close() })
+	return ra
+}
+
+func BenchmarkReadAt(b *testing.B) {
+	data := makeTestData(1024 * 1024)
+	ts := newTestServer(b, data, nil)
+	ra := newHeaderTestReaderAt(b, ts.URL)
+	p := make([]byte, 4096)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(p)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		off := int64(i*len(p)) % int64(len(data))
+		if _, err := ra.ReadAt(p, off); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkCopyReq compares copying the prototype request as copyReq does,
+// sharing the header values, to cloning the whole header.
+func BenchmarkCopyReq(b *testing.B) {
+	data := makeTestData(100)
+	ts := newTestServer(b, data, nil)
+	ra := newHeaderTestReaderAt(b, ts.URL)
+
+	b.Run("copyReq", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			req := ra.copyReq()
+			req.Header.Set("Range", "bytes=0-99")
+		}
+	})
+	b.Run("cloneHeader", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			req := ra.copyReq()
+			req.Header = cloneHeader(ra.header)
+			req.Header.Set("Range", "bytes=0-99")
+		}
+	})
+}
+
+// testBufferPool is a BufferPool backed by sync.Pool.
+type testBufferPool struct {
+	pool sync.Pool
+}
+
+func (p *testBufferPool) Get(size int) []byte {
+	if buf, ok := p.pool.Get().(*[]byte); ok && cap(*buf) >= size {
+		return (*buf)[:size]
+	}
+	return make([]byte, size)
+}
+
+func (p *testBufferPool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
+
+// BenchmarkBufferPool compares the allocations of ReadRanges, which reads
+// the parts of multipart responses to scratch buffers, with and without
+// WithBufferPool.
+func BenchmarkBufferPool(b *testing.B) {
+	data := makeTestData(1024 * 1024)
+	ts := newTestServer(b, data, nil)
+	ranges := []Range{{0, 16384}, {100000, 16384}, {200000, 16384}, {300000, 16384}}
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"nopool", nil},
+		{"pool", []Option{WithBufferPool(&testBufferPool{})}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			ra := newTestReaderAt(b, ts.URL, nil, bc.opts...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				results, err := ra.ReadRanges(ranges)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for _, r := range results {
+					if r.Err != nil {
+						b.Fatal(r.Err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReadAtChunked(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name    string
+		handler rangeHandler
+		opts    []Option
+		wantN   int
+		wantErr error
+	}{
+		{"chunked", rangeHandler{chunked: true}, nil, 100, nil},
+		{"strict content length", rangeHandler{chunked: true},
+			[]Option{WithStrictContentLength(true)}, 100, nil},
+		{"truncated", rangeHandler{chunked: true, trunc: 10}, nil, 90, io.EOF},
+		{"truncated strict EOF", rangeHandler{chunked: true, trunc: 10},
+			[]Option{WithStrictEOF(true)}, 90, io.ErrUnexpectedEOF},
+		{"overlong", rangeHandler{chunked: true, extra: 10},
+			nil, 100, nil},
+		{"overlong strict body length", rangeHandler{chunked: true, extra: 10},
+			[]Option{WithStrictBodyLength(true)}, 100, ErrBodyTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &tt.handler
+			h.data = data
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 500)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Fatalf("ReadAt = %d, %v; want %d, %v", n, err, tt.wantN, tt.wantErr)
+			}
+			if !bytes.Equal(p[:n], data[500:500+n]) {
+				t.Error("ReadAt returned wrong data")
+			}
+		})
+	}
+}
+
+func TestReadAtTotalLengthChanged(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantErr  error
+		wantSize int64
+	}{
+		{"default", nil, ErrValidationFailed, 1000},
+		{"live size", []Option{WithLiveSize(true)}, nil, 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &rangeHandler{data: data, total: "2000"}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			if ra.Size() != 1000 {
+				t.Fatalf("Size = %d; want 1000", ra.Size())
+			}
+			p := make([]byte, 100)
+			if _, err := ra.ReadAt(p, 100); err != tt.wantErr {
+				t.Errorf("ReadAt error = %v; want %v", err, tt.wantErr)
+			}
+			if ra.Size() != tt.wantSize {
+				t.Errorf("Size = %d; want %d", ra.Size(), tt.wantSize)
+			}
+		})
+		t.Run(tt.name+" CopyRange", func(t *testing.T) {
+			h := &rangeHandler{data: data, total: "2000"}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			var buf bytes.Buffer
+			if _, err := ra.CopyRange(&buf, 100, 100); err != tt.wantErr {
+				t.Errorf("CopyRange error = %v; want %v", err, tt.wantErr)
+			}
+			if ra.Size() != tt.wantSize {
+				t.Errorf("Size = %d; want %d", ra.Size(), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestReadAtTruncatedNearEOF(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"uncached", nil},
+		{"block cache", []Option{WithBlockCache(256, 16)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &rangeHandler{data: data, trunc: 10}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 950)
+			if n != 40 || err != io.EOF {
+				t.Fatalf("ReadAt = %d, %v; want 40, EOF", n, err)
+			}
+			if !bytes.Equal(p[:n], data[950:990]) {
+				t.Error("ReadAt returned wrong data")
+			}
+		})
+	}
+}
+
+// blockingHandler serves data, but blocks the requests other than the
+// probe until release is closed or the request is canceled. It sends the
+// requests it blocks to started and reports the canceled ones to canceled.
+type blockingHandler struct {
+	data     []byte
+	started  chan *http.Request
+	canceled chan *http.Request
+	release  chan struct{}
+}
+
+func newBlockingHandler(data []byte) *blockingHandler {
+	return &blockingHandler{
+		data:     data,
+		started:  make(chan *http.Request, 10),
+		canceled: make(chan *http.Request, 10),
+		release:  make(chan struct{}),
+	}
+}
+
+func (h *blockingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Range") != "bytes=0-0" {
+		h.started <- r
+		select {
+		case <-h.release:
+		case <-r.Context().Done():
+			h.canceled <- r
+			return
+		}
+	}
+	http.ServeContent(w, r, "", testModTime, bytes.NewReader(h.data))
+}
+
+func TestReadAtContextMaxConcurrency(t *testing.T) {
+	data := makeTestData(1000)
+	h := newBlockingHandler(data)
+	ts := newTestServer(t, data, h.ServeHTTP)
+	ra := newTestReaderAt(t, ts.URL, nil, WithMaxConcurrency(1))
+
+	// take the only slot
+	done := make(chan error)
+	go func() {
+		_, err := ra.ReadAt(make([]byte, 100), 100)
+		done <- err
+	}()
+	<-h.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	n, err := ra.ReadAtContext(ctx, make([]byte, 100), 300)
+	if n != 0 || err != context.Canceled {
+		t.Errorf("ReadAtContext = %d, %v; want 0, %v", n, err, context.Canceled)
+	}
+	if ts.Requests() != 2 {
+		t.Errorf("requests = %d; want 2", ts.Requests())
+	}
+	close(h.release)
+	if err = <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReadAtContextBatching(t *testing.T) {
+	data := makeTestData(1000)
+
+	t.Run("waiting", func(t *testing.T) {
+		ts := newTestServer(t, data, nil)
+		ra := newTestReaderAt(t, ts.URL, nil, WithReadBatching(time.Hour, 0))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		n, err := ra.ReadAtContext(ctx, make([]byte, 100), 100)
+		if n != 0 || err != context.DeadlineExceeded {
+			t.Errorf("ReadAtContext = %d, %v; want 0, %v", n, err, context.DeadlineExceeded)
+		}
+		// the canceled read is not issued
+		ra.Flush()
+		if ts.Requests() != 1 {
+			t.Errorf("requests = %d; want 1", ts.Requests())
+		}
+	})
+
+	t.Run("in flight", func(t *testing.T) {
+		h := newBlockingHandler(data)
+		ts := newTestServer(t, data, h.ServeHTTP)
+		ra := newTestReaderAt(t, ts.URL, nil, WithReadBatching(time.Millisecond, 100))
+
+		var cancels []context.CancelFunc
+		errs := make(chan error, 2)
+		for _, off := range []int64{100, 300} {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancels = append(cancels, cancel)
+			go func(ctx context.Context, off int64) {
+				_, err := ra.ReadAtContext(ctx, make([]byte, 100), off)
+				errs <- err
+			}(ctx, off)
+		}
+		<-h.started
+		for _, cancel := range cancels {
+			cancel()
+		}
+		for range cancels {
+			if err := <-errs; err != context.Canceled {
+				t.Errorf("ReadAtContext error = %v; want %v", err, context.Canceled)
+			}
+		}
+		select {
+		case <-h.canceled:
+		case <-time.After(5 * time.Second):
+			t.Error("the batched request was not canceled")
+		}
+		if ts.Requests() != 2 {
+			t.Errorf("requests = %d; want 2", ts.Requests())
+		}
+	})
+}
+
+func TestRangeOverflow(t *testing.T) {
+	tests := []struct {
+		off, n  int64
+		want    int64
+		wantErr error
+	}{
+		{0, 10, 10, nil},
+		{math.MaxInt64 - 10, 10, math.MaxInt64, nil},
+		{math.MaxInt64 - 10, 11, 0, errRangeOverflow},
+		{1, math.MaxInt64, 0, errRangeOverflow},
+		{0, -1, 0, errRangeOverflow},
+		{-1, 10, 0, errNegativeOffset},
+	}
+	for _, tt := range tests {
+		end, err := rangeEnd(tt.off, tt.n)
+		if end != tt.want || err != tt.wantErr {
+			t.Errorf("rangeEnd(%d, %d) = %d, %v; want %d, %v",
+				tt.off, tt.n, end, err, tt.want, tt.wantErr)
+		}
+	}
+
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+	if _, err := ra.ReadAt(make([]byte, 10), math.MaxInt64-5); err != errRangeOverflow {
+		t.Errorf("ReadAt error = %v; want %v", err, errRangeOverflow)
+	}
+	if _, err := ra.ReadAt(make([]byte, 10), math.MaxInt64-10); err != io.EOF {
+		t.Errorf("ReadAt error = %v; want %v", err, io.EOF)
+	}
+	if _, err := ra.ReadRanges([]Range{{0, 10}, {math.MaxInt64 - 5, 10}}); err != errRangeOverflow {
+		t.Errorf("ReadRanges error = %v; want %v", err, errRangeOverflow)
+	}
+
+	// a huge length is clamped to the size of the file
+	results, err := ra.ReadRanges([]Range{{990, math.MaxInt64 - 990}, {0, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; !bytes.Equal(r.Data, data[990:]) || r.Err != io.EOF {
+		t.Errorf("ReadRanges result = %d bytes, %v; want 10 bytes, EOF", len(r.Data), r.Err)
+	}
+	if r := results[1]; !bytes.Equal(r.Data, data[:10]) || r.Err != nil {
+		t.Errorf("ReadRanges result = %d bytes, %v; want 10 bytes", len(r.Data), r.Err)
+	}
+}
+
+func TestStrictProbe(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		contentRange string
+		wantErr      error
+	}{
+		{"bytes 0-0/1000", nil},
+		{"bytes 5-5/1000", ErrInconsistentProbe},
+		{"bytes 0-9/1000", ErrInconsistentProbe},
+		{"bytes 0-0/0", ErrInconsistentProbe},
+	}
+	for _, tt := range tests {
+		t.Run(tt.contentRange, func(t *testing.T) {
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Range", tt.contentRange)
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[:1])
+			})
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, nil, WithStrictProbe(true))
+			if err == nil {
+				ra.Close()
+			}
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Errorf("New error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnsatisfiableRangeProber(t *testing.T) {
+	data := makeTestData(1000)
+	// the server reveals the size only in "416 Range Not Satisfiable"
+	// responses
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		w.Header().Set("Accept-Ranges", "bytes")
+		if r.Method == "HEAD" {
+			return
+		}
+		first, last, ok := parseTestRange(r.Header.Get("Range"), int64(len(data)))
+		switch {
+		case !ok:
+			w.Write(data)
+		case first >= int64(len(data)):
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", len(data)))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		default:
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", first, last))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[first : last+1])
+		}
+	}
+	for _, p := range []Prober{
+		UnsatisfiableRangeProber{},
+		ProberChain{HeadProber{}, UnsatisfiableRangeProber{}},
+	} {
+		ts := newTestServer(t, data, handler)
+		ra := newTestReaderAt(t, ts.URL, nil, WithProber(p))
+		if ra.Size() != int64(len(data)) {
+			t.Errorf("%T: Size = %d; want %d", p, ra.Size(), len(data))
+		}
+		b := make([]byte, 100)
+		n, err := ra.ReadAt(b, 950)
+		if n != 50 || err != io.EOF || !bytes.Equal(b[:n], data[950:]) {
+			t.Errorf("%T: ReadAt = %d, %v; want 50, EOF", p, n, err)
+		}
+	}
+}
+
+func TestRevalidate(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name        string
+		etag        string
+		changed     bool // the file changes after the probe
+		status      int  // status of the responses after the probe if not zero
+		wantIfRange string
+		wantErr     error
+	}{
+		{"206 last modified", "", false, 0, testModTime.Format(http.TimeFormat), nil},
+		{"206 strong etag", `"abc"`, false, 0, `"abc"`, nil},
+		{"206 weak etag", `W/"abc"`, false, 0, testModTime.Format(http.TimeFormat), nil},
+		{"200 changed", "", true, 0, testModTime.Format(http.TimeFormat), ErrValidationFailed},
+		{"304", "", false, http.StatusNotModified, testModTime.Format(http.TimeFormat), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var probed int32
+			var ifRange atomic.Value
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				modTime := testModTime
+				if atomic.AddInt32(&probed, 1) > 1 {
+					ifRange.Store(r.Header.Get("If-Range"))
+					if tt.status != 0 {
+						w.WriteHeader(tt.status)
+						return
+					}
+					if tt.changed {
+						modTime = modTime.Add(time.Hour)
+					}
+				}
+				if tt.etag != "" {
+					w.Header().Set("ETag", tt.etag)
+				}
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+			})
+			ra := newTestReaderAt(t, ts.URL, nil, WithRevalidate(true))
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 100)
+			if tt.status != 0 {
+				he, ok := err.(*HTTPError)
+				if !ok || he.StatusCode != tt.status {
+					t.Errorf("ReadAt error = %v; want HTTPError %d", err, tt.status)
+				}
+			} else if err != tt.wantErr {
+				t.Errorf("ReadAt error = %v; want %v", err, tt.wantErr)
+			} else if err == nil && (n != 100 || !bytes.Equal(p, data[100:200])) {
+				t.Error("ReadAt returned wrong data")
+			}
+			if got, _ := ifRange.Load().(string); got != tt.wantIfRange {
+				t.Errorf("If-Range = %q; want %q", got, tt.wantIfRange)
+			}
+		})
+	}
+}
+
+func TestStrictEOF(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name    string
+		trunc   int
+		off     int64
+		strict  bool
+		wantN   int
+		wantErr error
+	}{
+		{"truncated", 10, 100, false, 90, io.EOF},
+		{"truncated strict", 10, 100, true, 90, io.ErrUnexpectedEOF},
+		{"end of file", 0, 950, false, 50, io.EOF},
+		{"end of file strict", 0, 950, true, 50, io.EOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &rangeHandler{data: data, trunc: tt.trunc}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, WithStrictEOF(tt.strict))
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, tt.off)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Errorf("ReadAt = %d, %v; want %d, %v", n, err, tt.wantN, tt.wantErr)
+			}
+		})
+	}
+}
+
+// droppingHandler serves data, but drops the connection after sending k
+// bytes of the body of each response other than the probe.
+func droppingHandler(data []byte, k int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		first, last, ok := parseTestRange(r.Header.Get("Range"), int64(len(data)))
+		if !ok || first == 0 && last == 0 {
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+			return
+		}
+		body := data[first : last+1]
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(data)))
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		w.WriteHeader(http.StatusPartialContent)
+		if len(body) > k {
+			w.Write(body[:k])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		w.Write(body)
+	}
+}
+
+func TestResumableReads(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name      string
+		k         int
+		resumable bool
+		wantN     int
+		wantErr   error
+		requests  int
+	}{
+		{"resumed", 30, true, 100, nil, 5},
+		{"too many resumes", 10, true, 40, io.EOF, 5},
+		{"not resumable", 30, false, 30, io.EOF, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, data, droppingHandler(data, tt.k))
+			ra := newTestReaderAt(t, ts.URL, nil, WithResumableReads(tt.resumable))
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 200)
+			if n != tt.wantN || err != tt.wantErr {
+				t.Fatalf("ReadAt = %d, %v; want %d, %v", n, err, tt.wantN, tt.wantErr)
+			}
+			if !bytes.Equal(p[:n], data[200:200+n]) {
+				t.Error("ReadAt returned wrong data")
+			}
+			if ts.Requests() != tt.requests {
+				t.Errorf("requests = %d; want %d", ts.Requests(), tt.requests)
+			}
+		})
+	}
+}
+
+// growingFile is a remote file which can be appended to and whose ETag can
+// be changed while it is served.
+type growingFile struct {
+	mu   sync.Mutex
+	data []byte
+	etag string
+}
+
+func (f *growingFile) set(data []byte, etag string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.data, f.etag = data, etag
+}
+
+func (f *growingFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	data, etag := f.data, f.etag
+	f.mu.Unlock()
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+}
+
+func TestRefreshSizeOnEOF(t *testing.T) {
+	data := makeTestData(1500)
+	f := &growingFile{data: data[:1000], etag: `"1"`}
+	ts := newTestServer(t, nil, f.ServeHTTP)
+	ra := newTestReaderAt(t, ts.URL, nil, WithRefreshSizeOnEOF(true))
+
+	p := make([]byte, 100)
+	if n, err := ra.ReadAt(p, 1000); n != 0 || err != io.EOF {
+		t.Fatalf("ReadAt = %d, %v; want 0, EOF", n, err)
+	}
+
+	f.set(data, `"1"`)
+	n, err := ra.ReadAt(p, 1000)
+	if n != 100 || err != nil || !bytes.Equal(p, data[1000:1100]) {
+		t.Fatalf("ReadAt = %d, %v; want 100 bytes of the appended data", n, err)
+	}
+	if ra.Size() != 1500 {
+		t.Errorf("Size = %d; want 1500", ra.Size())
+	}
+
+	// a changed file is still detected
+	f.set(makeTestData(2000), `"2"`)
+	if _, err = ra.ReadAt(p, 1500); err != ErrValidationFailed {
+		t.Errorf("ReadAt error = %v; want %v", err, ErrValidationFailed)
+	}
+}
+
+func TestRedirectLoop(t *testing.T) {
+	var ts *testServer
+	ts = newTestServer(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, ts.URL+"/loop", http.StatusFound)
+	})
+	stop := stderrors.New("too many redirects")
+	tests := []struct {
+		name          string
+		opts          []Option
+		wantRedirects int
+		wantErr       error
+	}{
+		{"default policy", nil, maxRedirects, nil},
+		{"custom policy", []Option{WithCheckRedirect(func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return stop
+			}
+			return nil
+		})}, 3, stop},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, nil, tt.opts...)
+			if err == nil {
+				ra.Close()
+				t.Fatal("New succeeded; want error")
+			}
+			re, ok := err.(*RedirectError)
+			if !ok {
+				t.Fatalf("New error = %T %v; want *RedirectError", err, err)
+			}
+			if re.Redirects != tt.wantRedirects {
+				t.Errorf("Redirects = %d; want %d", re.Redirects, tt.wantRedirects)
+			}
+			if tt.wantErr != nil && re.Err != tt.wantErr {
+				t.Errorf("Err = %v; want %v", re.Err, tt.wantErr)
+			}
+			if re.URL != ts.URL+"/loop" {
+				t.Errorf("URL = %q; want %q", re.URL, ts.URL+"/loop")
+			}
+		})
+	}
+}
+
+func TestBodyTooLong(t *testing.T) {
+	data := makeTestData(1000)
+	for _, strict := range []bool{false, true} {
+		h := &rangeHandler{data: data, extra: 10}
+		var conns int32
+		ts := httptest.NewUnstartedServer(h)
+		ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		ts.Start()
+		defer ts.Close()
+
+		ra := newTestReaderAt(t, ts.URL, nil, WithStrictBodyLength(strict))
+		for i := int64(0); i < 5; i++ {
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 100+i*100)
+			if strict {
+				if err != ErrBodyTooLong {
+					t.Errorf("strict: ReadAt error = %v; want %v", err, ErrBodyTooLong)
+				}
+				continue
+			}
+			if n != 100 || err != nil || !bytes.Equal(p, data[100+i*100:200+i*100]) {
+				t.Errorf("ReadAt = %d, %v; want 100 bytes", n, err)
+			}
+		}
+		// the extra bytes are drained so that the connection is reused
+		if n := atomic.LoadInt32(&conns); n != 1 {
+			t.Errorf("strict %v: %d connections; want 1", strict, n)
+		}
+	}
+}
+
+func TestRangeHeaderFormat(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name         string
+		format       RangeHeaderFormat
+		contentRange bool // the server sends Content-Range
+		wantRange    string
+		wantSize     int64
+	}{
+		{"inclusive", RFCInclusive, true, "bytes=100-199", 1000},
+		{"start length", StartLength, true, "bytes=100+100", 1000},
+		{"start length without content-range", StartLength, false, "bytes=100+100", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lastRange atomic.Value
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				s := r.Header.Get("Range")
+				lastRange.Store(s)
+				var first, n int64
+				if _, err := fmt.Sscanf(s, "bytes=%d+%d", &first, &n); err != nil {
+					var last int64
+					if _, err = fmt.Sscanf(s, "bytes=%d-%d", &first, &last); err != nil {
+						http.Error(w, "bad range", http.StatusBadRequest)
+						return
+					}
+					n = last - first + 1
+				}
+				if first+n > int64(len(data)) {
+					n = int64(len(data)) - first
+				}
+				w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+				if !tt.contentRange {
+					w.Write(data[first : first+n])
+					return
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, first+n-1, len(data)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write(data[first : first+n])
+			})
+			ra := newTestReaderAt(t, ts.URL, nil, WithRangeHeaderFormat(tt.format))
+			if ra.Size() != tt.wantSize {
+				t.Errorf("Size = %d; want %d", ra.Size(), tt.wantSize)
+			}
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 100)
+			if n != 100 || err != nil || !bytes.Equal(p, data[100:200]) {
+				t.Errorf("ReadAt = %d, %v; want 100 bytes", n, err)
+			}
+			if got := lastRange.Load(); got != tt.wantRange {
+				t.Errorf("Range = %q; want %q", got, tt.wantRange)
+			}
+		})
+	}
+}
+
+func TestETagOptional(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name         string
+		optional     bool
+		lastModified bool // the server sends Last-Modified
+		wantErr      error
+	}{
+		{"default", false, true, ErrValidationFailed},
+		{"optional", true, true, nil},
+		{"optional without last-modified", true, false, ErrValidationFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				// every request is served by a different node
+				n := atomic.AddInt32(&requests, 1)
+				w.Header().Set("ETag", fmt.Sprintf(`"node%d"`, n))
+				modTime := time.Time{}
+				if tt.lastModified {
+					modTime = testModTime
+				}
+				http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+			})
+			ra := newTestReaderAt(t, ts.URL, nil, WithETagOptional(tt.optional))
+			p := make([]byte, 100)
+			if _, err := ra.ReadAt(p, 100); err != tt.wantErr {
+				t.Errorf("ReadAt error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// failingStore is a Store which stores at most k bytes and then fails.
+type failingStore struct {
+	StoreMemory
+	k   int64
+	err error
+}
+
+func (s *failingStore) ReadFrom(r io.Reader) (int64, error) {
+	n, err := s.StoreMemory.ReadFrom(io.LimitReader(r, s.k))
+	if err == nil {
+		err = s.err
+	}
+	return n, err
+}
+
+func TestFallbackPartiallyFilled(t *testing.T) {
+	data := makeTestData(1000)
+	errStore := stderrors.New("store failed")
+
+	t.Run("store error", func(t *testing.T) {
+		ts := newTestServer(t, data, noRangeHandler(data))
+		bs := &failingStore{k: 300, err: errStore}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		ra, err := New(nil, req, bs)
+		if ra != nil || !stderrors.Is(err, errStore) {
+			t.Errorf("New = %v, %v; want nil, %v", ra, err, errStore)
+		}
+	})
+
+	t.Run("truncated body", func(t *testing.T) {
+		ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:300])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		})
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		ra, err := New(nil, req, NewStoreMemory())
+		if ra != nil || err == nil {
+			t.Errorf("New = %v, %v; want an error", ra, err)
+		}
+	})
+}
+
+// countingStore is a StoreMemory counting the ReadFrom calls.
+type countingStore struct {
+	StoreMemory
+	reads int
+}
+
+func (s *countingStore) ReadFrom(r io.Reader) (int64, error) {
+	s.reads++
+	return s.StoreMemory.ReadFrom(r)
+}
+
+func TestDisableFallback(t *testing.T) {
+	data := makeTestData(1000)
+
+	t.Run("probe", func(t *testing.T) {
+		ts := newTestServer(t, data, noRangeHandler(data))
+		bs := &countingStore{}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		ra, err := New(nil, req, bs, WithDisableFallback(true))
+		var nre *NoRangeError
+		if ra != nil || !stderrors.As(err, &nre) {
+			t.Errorf("New = %v, %v; want NoRangeError", ra, err)
+		}
+		if bs.reads != 0 {
+			t.Errorf("the Store was filled %d times", bs.reads)
+		}
+	})
+
+	t.Run("later request", func(t *testing.T) {
+		var ranges int32 = 1
+		ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+			if atomic.LoadInt32(&ranges) == 0 {
+				r.Header.Del("Range")
+			}
+			http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+		})
+		bs := &countingStore{}
+		ra := newTestReaderAt(t, ts.URL, bs, WithDisableFallback(true))
+		atomic.StoreInt32(&ranges, 0)
+		if _, err := ra.ReadAt(make([]byte, 100), 100); err != ErrRangeSupportLost {
+			t.Errorf("ReadAt error = %v; want %v", err, ErrRangeSupportLost)
+		}
+		if bs.reads != 0 {
+			t.Errorf("the Store was filled %d times", bs.reads)
+		}
+	})
+}
+
+func TestCheckTotalLength(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name    string
+		total   string
+		opts    []Option
+		wantErr error
+	}{
+		{"unchanged", "", []Option{WithCheckTotalLength(true)}, nil},
+		{"changed", "2000", []Option{WithCheckTotalLength(true)}, ErrValidationFailed},
+		{"changed within validation interval", "2000",
+			[]Option{WithCheckTotalLength(true), WithValidationInterval(time.Hour)}, ErrValidationFailed},
+		{"unknown", "*", []Option{WithCheckTotalLength(true)}, ErrValidationFailed},
+		{"unknown without check", "*", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &rangeHandler{data: data, total: tt.total}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			if _, err := ra.ReadAt(make([]byte, 100), 100); err != tt.wantErr {
+				t.Errorf("ReadAt error = %v; want %v", err, tt.wantErr)
+			}
+			if _, err := ra.CopyRange(ioutil.Discard, 300, 100); err != tt.wantErr {
+				t.Errorf("CopyRange error = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbeData(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"probe size", []Option{WithProbeSize(500)}},
+		{"prober", []Option{WithProber(RangeGetProber{Length: 500})}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, data, nil)
+			ra := newTestReaderAt(t, ts.URL, nil, tt.opts...)
+			probed := ts.Requests()
+			for _, r := range []Range{{0, 100}, {400, 100}, {0, 500}} {
+				p := make([]byte, r.Len)
+				n, err := ra.ReadAt(p, r.Off)
+				if n != len(p) || err != nil || !bytes.Equal(p, data[r.Off:r.Off+r.Len]) {
+					t.Errorf("ReadAt(%d, %d) = %d, %v", r.Off, r.Len, n, err)
+				}
+			}
+			if ts.Requests() != probed {
+				t.Errorf("requests after probe = %d; want 0", ts.Requests()-probed)
+			}
+
+			// reads extending past the probed data make a request
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 450)
+			if n != len(p) || err != nil || !bytes.Equal(p, data[450:550]) {
+				t.Errorf("ReadAt(450, 100) = %d, %v", n, err)
+			}
+			if ts.Requests() != probed+1 {
+				t.Errorf("requests after probe = %d; want 1", ts.Requests()-probed)
+			}
+		})
+	}
+}
+
+// gzipHandler serves data with Range Request support, compressing the
+// partial responses after the probe with gzip regardless of the requested
+// encoding. The last received Accept-Encoding header is stored in
+// acceptEncoding.
+type gzipHandler struct {
+	rangeHandler
+	mu             sync.Mutex
+	acceptEncoding string
+}
+
+func (h *gzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.acceptEncoding = r.Header.Get("Accept-Encoding")
+	h.mu.Unlock()
+	if atomic.LoadInt32(&h.requests) == 0 {
+		h.rangeHandler.ServeHTTP(w, r)
+		return
+	}
+	rec := httptest.NewRecorder()
+	h.rangeHandler.ServeHTTP(rec, r)
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	zw.Write(rec.Body.Bytes())
+	zw.Close()
+	for k, v := range rec.Header() {
+		w.Header()[k] = v
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	w.Header().Set("Content-Length", fmt.Sprint(b.Len()))
+	w.WriteHeader(rec.Code)
+	w.Write(b.Bytes())
+}
+
+func TestRangeEncoded(t *testing.T) {
+	data := makeTestData(1000)
+	for _, header := range []string{"Range", "x-ms-range"} {
+		t.Run(header, func(t *testing.T) {
+			h := &gzipHandler{rangeHandler: rangeHandler{data: data, header: header}}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			var opts []Option
+			if header != "Range" {
+				opts = append(opts, WithRangeHeaderName(header))
+			}
+			ra := newTestReaderAt(t, ts.URL, nil, opts...)
+			if _, err := ra.ReadAt(make([]byte, 100), 100); err != ErrRangeEncoded {
+				t.Errorf("ReadAt error = %v; want %v", err, ErrRangeEncoded)
+			}
+			h.mu.Lock()
+			ae := h.acceptEncoding
+			h.mu.Unlock()
+			if header != "Range" && ae != "identity" {
+				t.Errorf("Accept-Encoding = %q; want identity", ae)
+			}
+			if _, err := ra.CopyRange(ioutil.Discard, 300, 100); err != ErrRangeEncoded {
+				t.Errorf("CopyRange error = %v; want %v", err, ErrRangeEncoded)
+			}
+		})
+	}
+}
+
+// contentLengthTransport adds delta to the Content-Length of the responses
+// of the default transport, without affecting the length of the bodies.
+type contentLengthTransport struct {
+	delta int64
+}
+
+func (t contentLengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil && resp.ContentLength != -1 {
+		resp.ContentLength += t.delta
+	}
+	return resp, err
+}
+
+func TestStrictContentLength(t *testing.T) {
+	data := makeTestData(1000)
+	for _, strict := range []bool{false, true} {
+		for _, tt := range []struct {
+			name    string
+			trunc   int
+			extra   int
+			wantN   int
+			wantErr error
+		}{
+			{"over-length", 0, 10, 100, nil},
+			{"under-length", 10, 0, 90, io.EOF},
+		} {
+			name := fmt.Sprintf("%s strict %v", tt.name, strict)
+			h := &rangeHandler{data: data, trunc: tt.trunc, extra: tt.extra}
+			ts := newTestServer(t, data, h.ServeHTTP)
+			ra := newTestReaderAt(t, ts.URL, nil, WithStrictContentLength(strict))
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 100)
+			wantErr := tt.wantErr
+			if strict {
+				wantErr = ErrContentLengthMismatch
+			}
+			if n != tt.wantN || err != wantErr || !bytes.Equal(p[:n], data[100:100+n]) {
+				t.Errorf("%s: ReadAt = %d, %v; want %d, %v", name, n, err, tt.wantN, wantErr)
+			}
+		}
+
+		// The Store fallback is engaged when the server does not
+		// support Range Requests. The Content-Length reported by the
+		// transport is adjusted, as a server can not send a body
+		// differing from it over HTTP/1.1.
+		for _, delta := range []int64{-10, 10} {
+			name := fmt.Sprintf("fallback delta %d strict %v", delta, strict)
+			ts := newTestServer(t, data, noRangeHandler(data))
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, NewStoreMemory(), WithStrictContentLength(strict),
+				WithTransport(contentLengthTransport{delta}))
+			if strict {
+				if err == nil {
+					ra.Close()
+				}
+				if !stderrors.Is(err, ErrContentLengthMismatch) {
+					t.Errorf("%s: New error = %v; want %v", name, err, ErrContentLengthMismatch)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s: New error = %v", name, err)
+				continue
+			}
+			p := make([]byte, len(data))
+			if n, err := ra.ReadAt(p, 0); n != len(data) || err != nil || !bytes.Equal(p, data) {
+				t.Errorf("%s: ReadAt = %d, %v; want %d bytes", name, n, err, len(data))
+			}
+			ra.Close()
+		}
+	}
+}
+
+func TestFollowPermanentRedirects(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		start     string
+		code      int
+		follow    bool
+		wantHits  int32
+		wantFinal string
+	}{
+		{"/old", http.StatusMovedPermanently, true, 1, "/new"},
+		{"/old", http.StatusPermanentRedirect, true, 1, "/new"},
+		{"/old", http.StatusFound, true, 4, "/old"},
+		{"/old", http.StatusTemporaryRedirect, true, 4, "/old"},
+		{"/old", http.StatusPermanentRedirect, false, 4, "/old"},
+		// a permanent redirect following a temporary one is not
+		// remembered
+		{"/tmp", http.StatusPermanentRedirect, true, 4, "/tmp"},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %d follow %v", tt.start[1:], tt.code, tt.follow), func(t *testing.T) {
+			var hits int32
+			h := &rangeHandler{data: data}
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/tmp":
+					http.Redirect(w, r, "/old", http.StatusFound)
+					return
+				case "/old":
+					atomic.AddInt32(&hits, 1)
+					http.Redirect(w, r, "/new", tt.code)
+					return
+				}
+				h.ServeHTTP(w, r)
+			})
+			req, _ := http.NewRequest("GET", ts.URL+tt.start, nil)
+			ra, err := New(nil, req, nil, WithFollowPermanentRedirects(tt.follow))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+			for i := int64(0); i < 3; i++ {
+				p := make([]byte, 100)
+				n, err := ra.ReadAt(p, i*100)
+				if n != len(p) || err != nil || !bytes.Equal(p, data[i*100:i*100+100]) {
+					t.Errorf("ReadAt(%d) = %d, %v", i*100, n, err)
+				}
+			}
+			if n := atomic.LoadInt32(&hits); n != tt.wantHits {
+				t.Errorf("requests to the redirecting URL = %d; want %d", n, tt.wantHits)
+			}
+			if got := ra.copyReq().URL.Path; got != tt.wantFinal {
+				t.Errorf("prototype request path = %q; want %q", got, tt.wantFinal)
+			}
+		})
+	}
+}
+
+// fullRangeHandler serves data as "206 Partial Content" with the whole
+// file as the range, regardless of the requested range.
+func fullRangeHandler(data []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", len(data)-1, len(data)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(data)
+	}
+}
+
+func TestFallbackDecider(t *testing.T) {
+	data := makeTestData(1000)
+	partial := func(resp *http.Response) bool {
+		return resp.StatusCode == http.StatusPartialContent
+	}
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		decide      func(resp *http.Response) bool
+		wantBuffer  bool
+		wantNoRange bool
+		wantErr     bool
+	}{
+		{"200 rejected", noRangeHandler(data), partial, false, true, true},
+		{"206 whole file", fullRangeHandler(data), partial, true, false, false},
+		{"206 partial", (&rangeHandler{data: data}).ServeHTTP, partial, false, false, true},
+		{"206 not decided", (&rangeHandler{data: data}).ServeHTTP,
+			func(*http.Response) bool { return false }, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, data, tt.handler)
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, NewStoreMemory(), WithFallbackDecider(tt.decide))
+			if tt.wantErr {
+				if err == nil {
+					ra.Close()
+					t.Fatal("New succeeded; want error")
+				}
+				var nre *NoRangeError
+				if stderrors.As(err, &nre) != tt.wantNoRange {
+					t.Errorf("New error = %v; NoRangeError %v", err, tt.wantNoRange)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+			if ra.usebs != tt.wantBuffer {
+				t.Errorf("buffered = %v; want %v", ra.usebs, tt.wantBuffer)
+			}
+			probed := ts.Requests()
+			p := make([]byte, 100)
+			n, err := ra.ReadAt(p, 500)
+			if n != len(p) || err != nil || !bytes.Equal(p, data[500:600]) {
+				t.Errorf("ReadAt = %d, %v", n, err)
+			}
+			if tt.wantBuffer && ts.Requests() != probed {
+				t.Errorf("requests after buffering = %d; want 0", ts.Requests()-probed)
+			}
+		})
+	}
+}
+
+// unknownSizeHandler serves data with Range Request support, reporting an
+// unknown total length. The size is sent in response to HEAD requests
+// only if headSize is true.
+func unknownSizeHandler(data []byte, headSize bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		if r.Method == "HEAD" {
+			if headSize {
+				w.Header().Set("Accept-Ranges", "bytes")
+				w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			}
+			return
+		}
+		first, last, ok := parseTestRange(r.Header.Get("Range"), int64(len(data)))
+		if !ok || first >= int64(len(data)) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if last >= int64(len(data)) {
+			last = int64(len(data)) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", first, last))
+		w.WriteHeader(http.StatusPartialContent)
+		w.(http.Flusher).Flush()
+		w.Write(data[first : last+1])
+	}
+}
+
+func TestRequireKnownSize(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		name     string
+		headSize bool
+		require  bool
+		wantSize int64
+		wantErr  error
+	}{
+		{"unknown", false, false, -1, nil},
+		{"unknown required", false, true, -1, ErrUnknownSize},
+		{"known by HEAD required", true, true, 1000, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, data, unknownSizeHandler(data, tt.headSize))
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, nil, WithRequireKnownSize(tt.require))
+			if tt.wantErr != nil {
+				if err == nil {
+					ra.Close()
+				}
+				if !stderrors.Is(err, tt.wantErr) {
+					t.Errorf("New error = %v; want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+			if ra.Size() != tt.wantSize {
+				t.Errorf("Size = %d; want %d", ra.Size(), tt.wantSize)
+			}
+		})
+	}
+}
+
+func TestRangeHeaderName(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data, header: "x-ms-range"}
+	var mu sync.Mutex
+	var headers []http.Header
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		headers = append(headers, r.Header.Clone())
+		mu.Unlock()
+		h.ServeHTTP(w, r)
+	})
+	ra := newTestReaderAt(t, ts.URL, nil, WithRangeHeaderName("x-ms-range"))
+	p := make([]byte, 100)
+	if n, err := ra.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	var b bytes.Buffer
+	if _, err := ra.CopyRange(&b, 300, 100); err != nil || !bytes.Equal(b.Bytes(), data[300:400]) {
+		t.Errorf("CopyRange returned wrong data, %v", err)
+	}
+	// the multiple range request is answered with the whole file, and
+	// the ranges are then read one by one
+	results, err := ra.ReadRanges([]Range{{500, 10}, {700, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, res := range results {
+		if res.Err != nil || !bytes.Equal(res.Data, data[res.Off:res.Off+10]) {
+			t.Errorf("ReadRanges returned wrong data at %d, %v", res.Off, res.Err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(headers) != 6 {
+		t.Errorf("requests = %d; want 6", len(headers))
+	}
+	for i, hdr := range headers {
+		if hdr.Get("x-ms-range") == "" {
+			t.Errorf("request %d: no x-ms-range header", i)
+		}
+		if got := hdr.Get("Range"); got != "" {
+			t.Errorf("request %d: Range = %q; want none", i, got)
+		}
+		if got := hdr.Get("Accept-Encoding"); got != "identity" {
+			t.Errorf("request %d: Accept-Encoding = %q; want identity", i, got)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		str                 string
+		first, last, length int64
+		wantErr             bool
+	}{
+		{"bytes 42-1233/1234", 42, 1233, 1234, false},
+		{"bytes 42-1233/*", 42, 1233, -1, false},
+		{"bytes */1234", -1, -1, 1234, false},
+		{"  bytes   42-1233/1234  ", 42, 1233, 1234, false},
+		{"bytes 42 - 1233 / 1234", 42, 1233, 1234, false},
+		{"bytes\t42-1233/1234", 42, 1233, 1234, false},
+		{"bytes 0-9 9/100", 0, 0, 0, true},
+		{"bytes 1 0-19/100", 0, 0, 0, true},
+		{"bytes 0-99/10 0", 0, 0, 0, true},
+		{"bytes0-99/100", 0, 0, 0, true},
+		{"bits 0-99/100", 0, 0, 0, true},
+		{"bytes */*", 0, 0, 0, true},
+		{"bytes 0-99", 0, 0, 0, true},
+		{"bytes 0-50-99/100", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		first, last, length, err := parseContentRange(tt.str)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseContentRange(%q) = %d, %d, %d; want error", tt.str, first, last, length)
+			}
+			continue
+		}
+		if err != nil || first != tt.first || last != tt.last || length != tt.length {
+			t.Errorf("parseContentRange(%q) = %d, %d, %d, %v; want %d, %d, %d",
+				tt.str, first, last, length, err, tt.first, tt.last, tt.length)
+		}
+	}
+}
+
+func TestReadAtResp(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data}
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amz-Storage-Class", "STANDARD")
+		w.Header().Set("X-Range", r.Header.Get("Range"))
+		h.ServeHTTP(w, r)
+	})
+	ra := newTestReaderAt(t, ts.URL, nil)
+	p := make([]byte, 100)
+	n, hdr, err := ra.ReadAtResp(p, 100)
+	if n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("ReadAtResp = %d, %v", n, err)
+	}
+	if hdr.Get("X-Amz-Storage-Class") != "STANDARD" || hdr.Get("X-Range") != "bytes=100-199" {
+		t.Errorf("ReadAtResp returned the header %v; want the one of the range response", hdr)
+	}
+
+	// no header if the data is served from the Store
+	ts = newTestServer(t, data, noRangeHandler(data))
+	ra = newTestReaderAt(t, ts.URL, NewStoreMemory())
+	n, hdr, err = ra.ReadAtResp(p, 100)
+	if n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("fallback: ReadAtResp = %d, %v", n, err)
+	}
+	if hdr != nil {
+		t.Errorf("fallback: ReadAtResp returned the header %v; want nil", hdr)
+	}
+}
+
+func TestCaptureCookies(t *testing.T) {
+	data := makeTestData(1000)
+	for _, tt := range []struct {
+		capture bool
+		jar     bool
+		want    string
+	}{
+		{false, false, ""},
+		{true, false, "session=abc"},
+		// the cookie is not duplicated when the Jar manages it
+		{true, true, "session=abc"},
+	} {
+		h := &rangeHandler{data: data}
+		var mu sync.Mutex
+		var cookies []string
+		ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			cookies = append(cookies, r.Header.Get("Cookie"))
+			mu.Unlock()
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc"})
+			h.ServeHTTP(w, r)
+		})
+		client := &http.Client{}
+		if tt.jar {
+			client.Jar, _ = cookiejar.New(nil)
+		}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		ra, err := New(client, req, nil, WithCaptureCookies(tt.capture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAndCheck(t, ra, data, 100, 100)
+		ra.Close()
+
+		mu.Lock()
+		if len(cookies) != 2 || cookies[0] != "" || cookies[1] != tt.want {
+			t.Errorf("capture %v jar %v: cookies sent %q; want %q after the probe",
+				tt.capture, tt.jar, cookies, tt.want)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestProbeOpenEnded(t *testing.T) {
+	data := makeTestData(1024 * 1024)
+	h := &rangeHandler{data: data}
+	var probeRange atomic.Value
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		if probeRange.Load() == nil {
+			probeRange.Store(r.Header.Get("Range"))
+		}
+		if r.Header.Get("Range") == "bytes=0-0" {
+			// the total length is not reported for a 1 byte range
+			w.Header().Set("Content-Range", "bytes 0-0/*")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data[:1])
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	for _, tt := range []struct {
+		openEnded bool
+		wantRange string
+		wantSize  int64
+	}{
+		{false, "bytes=0-0", -1},
+		{true, "bytes=0-", int64(len(data))},
+	} {
+		probeRange = atomic.Value{}
+		ra := newTestReaderAt(t, ts.URL, nil, WithProbeOpenEnded(tt.openEnded))
+		if got := probeRange.Load(); got != tt.wantRange {
+			t.Errorf("open ended %v: probe range = %q; want %q", tt.openEnded, got, tt.wantRange)
+		}
+		if ra.Size() != tt.wantSize {
+			t.Errorf("open ended %v: Size = %d; want %d", tt.openEnded, ra.Size(), tt.wantSize)
+		}
+		// only the beginning of the open ended response body is read
+		if n := ra.Stats().BytesFetched; n != 1 {
+			t.Errorf("open ended %v: probe fetched %d bytes; want 1", tt.openEnded, n)
+		}
+		if tt.openEnded {
+			readAndCheck(t, ra, data, 1000, 100)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	data := makeTestData(1000)
+	data2 := bytes.Repeat([]byte("y"), 500)
+	fallback := newTestServer(t, data, noRangeHandler(data))
+	ts2 := newTestServer(t, data2, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"2"`)
+		(&rangeHandler{data: data2}).ServeHTTP(w, r)
+	})
+	ra := newTestReaderAt(t, fallback.URL, NewStoreMemory(), WithBlockCache(100, 0))
+	if !ra.usebs {
+		t.Fatal("the Store is not used")
+	}
+	readAndCheck(t, ra, data, 100, 100)
+
+	req, _ := http.NewRequest("GET", ts2.URL, nil)
+	if err := ra.Reset(req); err != nil {
+		t.Fatal(err)
+	}
+	if ra.usebs {
+		t.Error("the Store is used after Reset to a server supporting Range Requests")
+	}
+	if ra.Size() != int64(len(data2)) || ra.Meta().ETag != `"2"` {
+		t.Errorf("Meta = %+v; want the metadata of the new file", ra.Meta())
+	}
+	// the options, such as the block cache, are retained
+	readAndCheck(t, ra, data2, 100, 100)
+	requests := ts2.Requests()
+	readAndCheck(t, ra, data2, 150, 50)
+	if ts2.Requests() != requests {
+		t.Error("the block cache is not used after Reset")
+	}
+
+	// the block cache is cleared
+	data3 := bytes.Repeat([]byte("z"), 500)
+	ts3 := newTestServer(t, data3, nil)
+	req, _ = http.NewRequest("GET", ts3.URL, nil)
+	if err := ra.Reset(req); err != nil {
+		t.Fatal(err)
+	}
+	readAndCheck(t, ra, data3, 100, 100)
+
+	req, _ = http.NewRequest("POST", ts2.URL, nil)
+	if err := ra.Reset(req); err != errInvalidMethod {
+		t.Errorf("Reset with POST error = %v; want %v", err, errInvalidMethod)
+	}
+}
+
+func TestClone(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil, WithBlockCache(100, 0))
+	readAndCheck(t, ra, data, 100, 100)
+	requests := ts.Requests()
+
+	c := ra.Clone()
+	defer c.Close()
+	if ts.Requests() != requests {
+		t.Error("Clone probed the file again")
+	}
+	if c.Size() != ra.Size() || c.Meta() != ra.Meta() {
+		t.Errorf("clone Meta = %+v; want %+v", c.Meta(), ra.Meta())
+	}
+	// the block cache is shared
+	readAndCheck(t, c, data, 100, 100)
+	if ts.Requests() != requests {
+		t.Error("the clone does not use the shared block cache")
+	}
+	readAndCheck(t, c, data, 500, 100)
+	readAndCheck(t, ra, data, 500, 100)
+	if ts.Requests() != requests+1 {
+		t.Errorf("requests = %d; want %d", ts.Requests(), requests+1)
+	}
+	// but the Stats are not
+	if n := ra.Stats().ReadAtCalls; n != 2 {
+		t.Errorf("ReadAtCalls = %d; want 2", n)
+	}
+	if n := c.Stats().ReadAtCalls; n != 2 {
+		t.Errorf("clone ReadAtCalls = %d; want 2", n)
+	}
+}
+
+func TestCloneOwnedStore(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, noRangeHandler(data))
+	ra := newTestReaderAt(t, ts.URL, nil, WithAutoStore(true))
+	c := ra.Clone()
+	readAndCheck(t, c, data, 100, 100)
+	// closing the clone does not close the Store owned by ra
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	readAndCheck(t, ra, data, 100, 100)
+	if ts.Requests() != 1 {
+		t.Errorf("requests = %d; want 1", ts.Requests())
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	data := makeTestData(2*warmUpChunkSize + 1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil, WithBlockCache(1000, 0))
+	probed := ts.Requests()
+	n, err := ra.WarmUp(context.Background())
+	if n != int64(len(data)) || err != nil {
+		t.Errorf("WarmUp = %d, %v; want %d, nil", n, err, len(data))
+	}
+	if r := ts.Requests() - probed; r != 3 {
+		t.Errorf("WarmUp made %d requests; want 3", r)
+	}
+	// the data is not retained
+	readAndCheck(t, ra, data, 5000, 100)
+	if r := ts.Requests() - probed; r != 4 {
+		t.Error("the data read by WarmUp was retained in the block cache")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := ra.WarmUp(ctx); n != 0 || err != context.Canceled {
+		t.Errorf("canceled WarmUp = %d, %v; want 0, %v", n, err, context.Canceled)
+	}
+}
+
+func TestURLRefresher(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data}
+	var mu sync.Mutex
+	valid := "1"
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.URL.Query().Get("sig") == valid
+		mu.Unlock()
+		if !ok {
+			http.Error(w, "expired", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	var refreshes int32
+	refresh := func(ctx context.Context) (*http.Request, error) {
+		atomic.AddInt32(&refreshes, 1)
+		mu.Lock()
+		defer mu.Unlock()
+		return http.NewRequest("GET", ts.URL+"?sig="+valid, nil)
+	}
+	ra := newTestReaderAt(t, ts.URL+"?sig=1", nil, WithURLRefresher(refresh))
+	readAndCheck(t, ra, data, 100, 100)
+
+	// the URL expires
+	mu.Lock()
+	valid = "2"
+	mu.Unlock()
+	readAndCheck(t, ra, data, 200, 100)
+	readAndCheck(t, ra, data, 300, 100)
+	if n := atomic.LoadInt32(&refreshes); n != 1 {
+		t.Errorf("refreshes = %d; want 1", n)
+	}
+	if q := ra.copyReq().URL.RawQuery; q != "sig=2" {
+		t.Errorf("prototype request query = %q; want sig=2", q)
+	}
+
+	// the refreshed URL does not help
+	mu.Lock()
+	valid = "3"
+	mu.Unlock()
+	refresh2 := func(ctx context.Context) (*http.Request, error) {
+		return http.NewRequest("GET", ts.URL+"?sig=old", nil)
+	}
+	ra = newTestReaderAt(t, ts.URL+"?sig=3", nil, WithURLRefresher(refresh2))
+	mu.Lock()
+	valid = "4"
+	mu.Unlock()
+	_, err := ra.ReadAt(make([]byte, 100), 100)
+	if he, ok := err.(*HTTPError); !ok || he.StatusCode != http.StatusForbidden {
+		t.Errorf("ReadAt error = %v; want 403 HTTPError", err)
+	}
+}
@@ -0,0 +1,181 @@
+// Package httpreaderattest provides utilities for testing code which uses
+// httpreaderat against real world servers.
+//
+// Recorder is a http.RoundTripper which records the request/response
+// pairs made through it. The recorded interactions can be saved to
+// a golden file and later replayed with Replayer without network access,
+// making the quirks of a particular server reproducible in tests.
+package httpreaderattest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Interaction is a single recorded request/response pair.
+type Interaction struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Range      string      `json:"range,omitempty"`
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+}
+
+func (i *Interaction) key() string {
+	return i.Method + " " + i.URL + " " + i.Range
+}
+
+func requestKey(req *http.Request) string {
+	return req.Method + " " + req.URL.String() + " " + req.Header.Get("Range")
+}
+
+// Recorder is a http.RoundTripper which records the interactions made
+// through it. It is safe for concurrent use.
+type Recorder struct {
+	// Transport is the underlying http.RoundTripper. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+var _ http.RoundTripper = (*Recorder)(nil)
+
+// RoundTrip implements the http.RoundTripper interface. The response body
+// is read fully in order to record it.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	t := r.Transport
+	if t == nil {
+		t = http.DefaultTransport
+	}
+	resp, err := t.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	r.mu.Lock()
+	r.interactions = append(r.interactions, Interaction{
+		Method:     req.Method,
+		URL:        req.URL.String(),
+		Range:      req.Header.Get("Range"),
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	})
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// Interactions returns the interactions recorded so far.
+func (r *Recorder) Interactions() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Interaction(nil), r.interactions...)
+}
+
+// Save writes the recorded interactions to w as JSON.
+func (r *Recorder) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r.Interactions())
+}
+
+// SaveFile writes the recorded interactions to the named golden file.
+func (r *Recorder) SaveFile(name string) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = r.Save(f)
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// Replayer is a http.RoundTripper which replays recorded interactions.
+// Requests are matched by method, URL and "Range" header. If the same
+// request was recorded several times, the responses are replayed in the
+// recorded order. It is safe for concurrent use.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+var _ http.RoundTripper = (*Replayer)(nil)
+
+// NewReplayer creates a Replayer which replays the given interactions.
+func NewReplayer(interactions []Interaction) *Replayer {
+	r := &Replayer{interactions: make(map[string][]Interaction)}
+	for _, i := range interactions {
+		r.interactions[i.key()] = append(r.interactions[i.key()], i)
+	}
+	return r
+}
+
+// LoadReplayer creates a Replayer from interactions read from rd as saved
+// by Recorder.Save.
+func LoadReplayer(rd io.Reader) (*Replayer, error) {
+	var interactions []Interaction
+	err := json.NewDecoder(rd).Decode(&interactions)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(interactions), nil
+}
+
+// LoadReplayerFile creates a Replayer from the named golden file saved by
+// Recorder.SaveFile.
+func LoadReplayerFile(name string) (*Replayer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return LoadReplayer(f)
+}
+
+// RoundTrip implements the http.RoundTripper interface. An error is
+// returned if there is no recorded interaction matching req.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := requestKey(req)
+
+	r.mu.Lock()
+	list := r.interactions[key]
+	if len(list) == 0 {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("no recorded interaction for %s", key)
+	}
+	i := list[0]
+	if len(list) > 1 {
+		r.interactions[key] = list[1:]
+	}
+	r.mu.Unlock()
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", i.StatusCode, http.StatusText(i.StatusCode)),
+		StatusCode:    i.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        i.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(i.Body)),
+		ContentLength: int64(len(i.Body)),
+		Request:       req,
+	}, nil
+}
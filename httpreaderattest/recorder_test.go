@@ -0,0 +1,76 @@
+package httpreaderattest
+
+import (
+	"bytes"
+	"github.com/snabb/httpreaderat"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// readAll makes the same ReadAt calls through transport and returns the
+// data read.
+func readAll(t *testing.T, url string, transport http.RoundTripper) [][]byte {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ra, err := httpreaderat.New(nil, req, nil, httpreaderat.WithTransport(transport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ra.Close()
+
+	var out [][]byte
+	for _, off := range []int64{0, 500, 0, 900} {
+		p := make([]byte, 100)
+		n, err := ra.ReadAt(p, off)
+		if err != nil {
+			t.Fatalf("ReadAt(%d): %v", off, err)
+		}
+		out = append(out, p[:n])
+	}
+	return out
+}
+
+func TestRecordReplay(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	}))
+	url := ts.URL
+
+	rec := &Recorder{}
+	recorded := readAll(t, url, rec)
+	ts.Close()
+	if len(rec.Interactions()) == 0 {
+		t.Fatal("no interactions recorded")
+	}
+
+	var golden bytes.Buffer
+	if err := rec.Save(&golden); err != nil {
+		t.Fatal(err)
+	}
+	rep, err := LoadReplayer(&golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := readAll(t, url, rep)
+	for i := range recorded {
+		if !bytes.Equal(recorded[i], replayed[i]) {
+			t.Errorf("read %d: replayed data differs from the recorded data", i)
+		}
+	}
+
+	// a request which was not recorded can not be replayed
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Range", "bytes=100-199")
+	if _, err = rep.RoundTrip(req); err == nil {
+		t.Error("RoundTrip succeeded for a request which was not recorded")
+	}
+}
@@ -0,0 +1,131 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func isLocalURL(u *url.URL) bool {
+	return u.Scheme == "file" || u.Scheme == "data"
+}
+
+// openLocal sets up HTTPReaderAt to serve a "file" or "data" URL from
+// a local Store without making any HTTP requests.
+func (ra *HTTPReaderAt) openLocal() (err error) {
+	switch ra.req.URL.Scheme {
+	case "file":
+		err = ra.openFileURL(ra.req.URL)
+	case "data":
+		err = ra.openDataURL(ra.req.URL)
+	}
+	if err != nil {
+		return err
+	}
+	ra.usebs = true
+	ra.ownbs = true
+	return nil
+}
+
+func (ra *HTTPReaderAt) openFileURL(u *url.URL) error {
+	if u.Host != "" && u.Host != "localhost" {
+		return errors.Errorf("unsupported file URL host: %s", u.Host)
+	}
+	f, err := os.Open(filepath.FromSlash(u.Path))
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	ra.bs = &localStore{ReaderAt: f, Closer: f}
+	ra.meta = Meta{
+		Size:         fi.Size(),
+		LastModified: fi.ModTime().UTC().Format(http.TimeFormat),
+		ContentType:  mime.TypeByExtension(filepath.Ext(u.Path)),
+	}
+	return nil
+}
+
+// openDataURL parses a "data" URL as specified in RFC 2397.
+func (ra *HTTPReaderAt) openDataURL(u *url.URL) error {
+	str := u.Opaque
+	if str == "" {
+		str = strings.TrimPrefix(u.Path, "/")
+	}
+	i := strings.IndexByte(str, ',')
+	if i == -1 {
+		return errors.New("invalid data URL")
+	}
+	mediaType, str := str[:i], str[i+1:]
+
+	data, err := url.PathUnescape(str)
+	if err != nil {
+		return errors.Wrap(err, "invalid data URL")
+	}
+	b := []byte(data)
+	if strings.HasSuffix(mediaType, ";base64") {
+		mediaType = strings.TrimSuffix(mediaType, ";base64")
+		b, err = base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return errors.Wrap(err, "invalid data URL")
+		}
+	}
+	if mediaType == "" || strings.HasPrefix(mediaType, ";") {
+		mediaType = "text/plain" + mediaType
+		if !strings.Contains(mediaType, "charset=") {
+			mediaType += ";charset=US-ASCII"
+		}
+	}
+	ra.bs = &localStore{ReaderAt: bytes.NewReader(b)}
+	ra.meta = Meta{
+		Size:        int64(len(b)),
+		ContentType: mediaType,
+	}
+	return nil
+}
+
+// localStore is a read only Store which serves a local file or a memory
+// buffer.
+type localStore struct {
+	io.ReaderAt
+	io.Closer
+}
+
+var _ Store = (*localStore)(nil)
+
+func (s *localStore) ReadFrom(r io.Reader) (n int64, err error) {
+	return 0, errors.New("local store is read only")
+}
+
+func (s *localStore) Close() error {
+	if s.Closer == nil {
+		return nil
+	}
+	return s.Closer.Close()
+}
+
+// NewFromReaderAt creates an HTTPReaderAt which serves the first size
+// bytes of r instead of a remote file, reporting meta as the metadata
+// (meta.Size is set to size). No HTTP requests are made. It is meant for
+// testing code which uses HTTPReaderAt without a HTTP server. r is not
+// closed by Close.
+func NewFromReaderAt(r io.ReaderAt, size int64, meta Meta) *HTTPReaderAt {
+	meta.Size = size
+	return &HTTPReaderAt{
+		client:   http.DefaultClient,
+		meta:     meta,
+		bs:       &localStore{ReaderAt: io.NewSectionReader(r, 0, size)},
+		usebs:    true,
+		counters: &counters{},
+	}
+}
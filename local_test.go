@@ -0,0 +1,86 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFromReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	meta := Meta{ETag: `"x"`, ContentType: "application/octet-stream"}
+	ra := NewFromReaderAt(bytes.NewReader(data), 500, meta)
+	defer ra.Close()
+
+	if ra.Size() != 500 {
+		t.Errorf("Size = %d; want 500", ra.Size())
+	}
+	if m := ra.Meta(); m.ETag != meta.ETag || m.ContentType != meta.ContentType {
+		t.Errorf("Meta = %+v; want %+v", m, meta)
+	}
+	if u := ra.ResolvedURL(); u != nil {
+		t.Errorf("ResolvedURL = %v; want nil", u)
+	}
+	p := make([]byte, 100)
+	if n, err := ra.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	// only the first size bytes are served
+	n, err := ra.ReadAt(p, 450)
+	if n != 50 || err != io.EOF || !bytes.Equal(p[:n], data[450:500]) {
+		t.Errorf("ReadAt(450) = %d, %v; want 50, EOF", n, err)
+	}
+}
+
+func TestLocalURLs(t *testing.T) {
+	data := makeTestData(1000)
+	name := filepath.Join(t.TempDir(), "test.txt")
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fileURL := (&url.URL{Scheme: "file", Path: filepath.ToSlash(name)}).String()
+	tests := []struct {
+		name            string
+		url             string
+		want            []byte
+		wantContentType string
+	}{
+		{"file", fileURL, data, mime.TypeByExtension(".txt")},
+		{"data", "data:,hello%20world", []byte("hello world"), "text/plain;charset=US-ASCII"},
+		{"data base64", "data:application/octet-stream;base64," + base64.StdEncoding.EncodeToString(data),
+			data, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ra, err := New(nil, req, nil); err == nil {
+				ra.Close()
+				t.Error("New succeeded without WithLocalURLs")
+			}
+			ra, err := New(nil, req, nil, WithLocalURLs(true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+			if ra.Size() != int64(len(tt.want)) {
+				t.Errorf("Size = %d; want %d", ra.Size(), len(tt.want))
+			}
+			if ct := ra.ContentType(); ct != tt.wantContentType {
+				t.Errorf("ContentType = %q; want %q", ct, tt.wantContentType)
+			}
+			p := make([]byte, len(tt.want))
+			if n, err := ra.ReadAt(p, 0); n != len(p) || err != nil || !bytes.Equal(p, tt.want) {
+				t.Errorf("ReadAt = %d, %v", n, err)
+			}
+		})
+	}
+}
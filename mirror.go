@@ -0,0 +1,64 @@
+package httpreaderat
+
+import (
+	"github.com/pkg/errors"
+	"io"
+)
+
+// MirrorReaderAt is io.ReaderAt implementation which reads from a primary
+// io.ReaderAt and falls back to a secondary io.ReaderAt if the read from
+// the primary fails. It is safe for concurrent use if the underlying
+// io.ReaderAt implementations are.
+type MirrorReaderAt struct {
+	primary   io.ReaderAt
+	secondary io.ReaderAt
+	size      int64
+}
+
+var _ io.ReaderAt = (*MirrorReaderAt)(nil)
+
+// ErrMirrorSizeMismatch error is returned by NewMirrorReaderAt if the
+// primary and the secondary report different sizes.
+var ErrMirrorSizeMismatch = errors.New("mirror size mismatch")
+
+type sizer interface {
+	Size() int64
+}
+
+// NewMirrorReaderAt creates a new MirrorReaderAt. Typically primary and
+// secondary are HTTPReaderAt instances accessing the same file on two
+// different mirrors. If both of them implement the Size() method (as
+// HTTPReaderAt does), the sizes must match to avoid mixing data of
+// different files, otherwise ErrMirrorSizeMismatch is returned.
+func NewMirrorReaderAt(primary, secondary io.ReaderAt) (*MirrorReaderAt, error) {
+	size := int64(-1)
+	ps, ok1 := primary.(sizer)
+	ss, ok2 := secondary.(sizer)
+	if ok1 && ok2 {
+		if ps.Size() != ss.Size() {
+			return nil, ErrMirrorSizeMismatch
+		}
+		size = ps.Size()
+	}
+	return &MirrorReaderAt{
+		primary:   primary,
+		secondary: secondary,
+		size:      size,
+	}, nil
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off from the primary.
+// If that fails with any other error than io.EOF, the same range is read
+// from the secondary.
+func (m *MirrorReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = m.primary.ReadAt(p, off)
+	if err == nil || err == io.EOF {
+		return n, err
+	}
+	return m.secondary.ReadAt(p, off)
+}
+
+// Size returns the size of the file or -1 if it is not known.
+func (m *MirrorReaderAt) Size() int64 {
+	return m.size
+}
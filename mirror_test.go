@@ -0,0 +1,58 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMirrorReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	h := &rangeHandler{data: data}
+	var failing int32
+	primary := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&failing) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+	secondary := newTestServer(t, data, nil)
+	m, err := NewMirrorReaderAt(newTestReaderAt(t, primary.URL, nil),
+		newTestReaderAt(t, secondary.URL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Size() != int64(len(data)) {
+		t.Errorf("Size = %d; want %d", m.Size(), len(data))
+	}
+
+	secondaryProbed := secondary.Requests()
+	p := make([]byte, 100)
+	if n, err := m.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("ReadAt = %d, %v", n, err)
+	}
+	if secondary.Requests() != secondaryProbed {
+		t.Error("the secondary was read although the primary succeeded")
+	}
+
+	atomic.StoreInt32(&failing, 1)
+	if n, err := m.ReadAt(p, 300); n != len(p) || err != nil || !bytes.Equal(p, data[300:400]) {
+		t.Errorf("ReadAt with a failing primary = %d, %v", n, err)
+	}
+	if secondary.Requests() != secondaryProbed+1 {
+		t.Error("the secondary was not read although the primary failed")
+	}
+}
+
+func TestMirrorReaderAtSizeMismatch(t *testing.T) {
+	data := makeTestData(1000)
+	primary := newTestServer(t, data, nil)
+	secondary := newTestServer(t, data[:999], nil)
+	_, err := NewMirrorReaderAt(newTestReaderAt(t, primary.URL, nil),
+		newTestReaderAt(t, secondary.URL, nil))
+	if err != ErrMirrorSizeMismatch {
+		t.Errorf("NewMirrorReaderAt error = %v; want %v", err, ErrMirrorSizeMismatch)
+	}
+}
@@ -0,0 +1,259 @@
+package httpreaderat
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Result is the result of reading one of the ranges given to ReadRanges.
+// Data contains the bytes read starting at byte offset Off. Err is
+// non-nil if fewer than the requested number of bytes were read; at end of
+// file it is io.EOF.
+type Result struct {
+	Off  int64
+	Data []byte
+	Err  error
+}
+
+// rangePart is a part of a multipart/byteranges response.
+type rangePart struct {
+	first int64
+	data  []byte
+}
+
+// ReadRanges reads the given ranges of the remote file. The ranges are
+// first requested with a single multiple range request. If the server
+// does not respond with the requested ranges (many servers send them as
+// a multipart/byteranges response, but some respond with a single
+// range or the whole file instead), the missing ranges are read with
+// concurrent ReadAt calls, limited by WithMaxConcurrency if set. The
+// results are returned in the order of ranges. The returned error is
+// non-nil only if ranges is invalid; errors reading individual ranges
+// are reported in the Results. If the size of the file is unknown, the
+// length of each range must fit in an int.
+func (ra *HTTPReaderAt) ReadRanges(ranges []Range) ([]Result, error) {
+	size := ra.Size()
+	for _, r := range ranges {
+		if _, err := rangeEnd(r.Off, r.Len); err != nil {
+			return nil, err
+		}
+		if size == -1 && r.Len > maxInt {
+			return nil, errRangeOverflow
+		}
+	}
+	ctx, cancel := ra.operationContext(context.Background())
+	defer cancel()
+
+	var parts []rangePart
+	if len(ranges) > 1 && !ra.usebs && ra.rangeEncoder == nil {
+		parts = ra.fetchMultiRange(ctx, ranges)
+	}
+	defer ra.putParts(parts)
+	results := make([]Result, len(ranges))
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		results[i].Off = r.Off
+		if data, eof, ok := assembleRange(parts, r, size); ok {
+			results[i].Data = data
+			if eof {
+				results[i].Err = io.EOF
+			}
+			continue
+		}
+		clipped := false
+		if size != -1 && r.Len > size-r.Off {
+			r.Len, clipped = size-r.Off, true
+			if r.Len < 0 {
+				r.Len = 0
+			}
+		}
+		wg.Add(1)
+		go func(res *Result, r Range, clipped bool) {
+			defer wg.Done()
+			buf := make([]byte, r.Len)
+			n, err := ra.ReadAtContext(ctx, buf, r.Off)
+			if err == nil && clipped {
+				err = io.EOF
+			}
+			res.Data, res.Err = buf[:n], err
+		}(&results[i], r, clipped)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// assembleRange copies the range r from parts. It reports false if the
+// parts do not cover the whole range up to the end of the file.
+func assembleRange(parts []rangePart, r Range, size int64) (data []byte, eof, ok bool) {
+	end := r.Off + r.Len
+	if size != -1 && end > size {
+		end, eof = size, true
+	}
+	if end <= r.Off {
+		return []byte{}, eof, size != -1
+	}
+	// check that the range is covered before allocating for it
+	var covering []rangePart
+	pos := r.Off
+	for _, pt := range parts {
+		ptEnd := pt.first + int64(len(pt.data))
+		if pt.first > pos || ptEnd <= pos {
+			continue
+		}
+		covering = append(covering, pt)
+		pos = ptEnd
+		if pos >= end {
+			break
+		}
+	}
+	if pos < end {
+		return nil, false, false
+	}
+	data = make([]byte, end-r.Off)
+	pos = r.Off
+	for _, pt := range covering {
+		pos += int64(copy(data[pos-r.Off:], pt.data[pos-pt.first:]))
+	}
+	return data, eof, true
+}
+
+// fetchMultiRange requests the ranges with a single multiple range
+// request and returns the received parts sorted by offset. Any failure
+// results in no parts being returned; the ranges are then read one by one
+// which also reports the errors.
+func (ra *HTTPReaderAt) fetchMultiRange(ctx context.Context, ranges []Range) (parts []rangePart) {
+	size := ra.Size()
+	var specs []string
+	var requested []Range
+	for _, r := range ranges {
+		last := r.Off + r.Len - 1
+		if size != -1 && last > size-1 {
+			last = size - 1
+		}
+		if last >= r.Off {
+			specs = append(specs, fmt.Sprintf("%d-%d", r.Off, last))
+			requested = append(requested, Range{r.Off, last - r.Off + 1})
+		}
+	}
+	if len(specs) < 2 {
+		return nil
+	}
+	req := ra.copyReq().WithContext(ctx)
+	req.Header.Set(ra.rangeHeaderName(), "bytes="+strings.Join(specs, ","))
+	if ra.dataEncoding != "" {
+		req.Header.Set("Accept-Encoding", ra.dataEncoding)
+	}
+	requestIdentity(req)
+
+	if ra.sem != nil {
+		select {
+		case ra.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil
+		}
+		defer func() { <-ra.sem }()
+	}
+	atomic.AddInt64(&ra.counters.networkReads, 1)
+	resp, err := ra.do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent || isContentEncoded(resp) {
+		return nil
+	}
+	m := ra.respMeta(resp)
+	if cur := ra.Meta(); m.LastModified != cur.LastModified ||
+		(m.ETag != cur.ETag && !ra.etagOptional) {
+		return nil
+	}
+	if cr := resp.Header.Get("Content-Range"); cr != "" {
+		// the server responded with a single range
+		pt, err := ra.readRangePart(resp.Body, cr, requested)
+		if err != nil {
+			return nil
+		}
+		return []rangePart{pt}
+	}
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" || params["boundary"] == "" {
+		return nil
+	}
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		var pt rangePart
+		if err == nil {
+			pt, err = ra.readRangePart(p, p.Header.Get("Content-Range"), requested)
+		}
+		if err != nil {
+			ra.putParts(parts)
+			return nil
+		}
+		parts = append(parts, pt)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].first < parts[j].first
+	})
+	return parts
+}
+
+// readRangePart reads the data of a range described by the Content-Range
+// header value cr from r. The range must be within one of the requested
+// ranges, which also bounds the size of the buffer allocated for it.
+func (ra *HTTPReaderAt) readRangePart(r io.Reader, cr string, requested []Range) (pt rangePart, err error) {
+	first, last, length, err := parseContentRange(cr)
+	if err != nil {
+		return pt, err
+	}
+	if first < 0 || last < first || !withinRanges(first, last, requested) {
+		return pt, errors.Errorf("received unrequested range %d-%d", first, last)
+	}
+	if ra.checkTotal {
+		err = ra.checkTotalLength(length)
+	} else if length != -1 {
+		err = ra.checkSize(length)
+	}
+	if err != nil {
+		return pt, err
+	}
+	data := ra.getBuf(int(last - first + 1))
+	n, err := io.ReadFull(r, data)
+	atomic.AddInt64(&ra.counters.bytesFetched, int64(n))
+	if err != nil {
+		ra.putBuf(data)
+		return pt, err
+	}
+	return rangePart{first: first, data: data}, nil
+}
+
+// withinRanges reports whether first...last (inclusive) is within one of
+// ranges.
+func withinRanges(first, last int64, ranges []Range) bool {
+	for _, r := range ranges {
+		if first >= r.Off && last-r.Off < r.Len {
+			return true
+		}
+	}
+	return false
+}
+
+// putParts returns the data of parts to the BufferPool.
+func (ra *HTTPReaderAt) putParts(parts []rangePart) {
+	for _, pt := range parts {
+		ra.putBuf(pt.data)
+	}
+}
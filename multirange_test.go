@@ -0,0 +1,94 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// checkResults checks that results match the ranges of data.
+func checkResults(t *testing.T, results []Result, ranges []Range, data []byte) {
+	t.Helper()
+	if len(results) != len(ranges) {
+		t.Fatalf("got %d results; want %d", len(results), len(ranges))
+	}
+	size := int64(len(data))
+	for i, r := range ranges {
+		end := r.Off + r.Len
+		var wantErr error
+		if end > size {
+			end, wantErr = size, io.EOF
+		}
+		res := results[i]
+		if res.Off != r.Off || res.Err != wantErr || !bytes.Equal(res.Data, data[r.Off:end]) {
+			t.Errorf("result %d = off %d, %d bytes, %v; want off %d, %d bytes, %v",
+				i, res.Off, len(res.Data), res.Err, r.Off, end-r.Off, wantErr)
+		}
+	}
+}
+
+func TestReadRanges(t *testing.T) {
+	data := makeTestData(10000)
+	ranges := []Range{{5000, 100}, {10, 20}, {9950, 100}, {400, 300}}
+
+	// http.ServeContent responds with multipart/byteranges
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+	before := ts.Requests()
+	results, err := ra.ReadRanges(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, ranges, data)
+	if n := ts.Requests() - before; n != 1 {
+		t.Errorf("multipart ReadRanges made %d requests; want 1", n)
+	}
+
+	// rangeHandler supports only single ranges
+	ts = newTestServer(t, data, (&rangeHandler{data: data}).ServeHTTP)
+	ra = newTestReaderAt(t, ts.URL, nil)
+	before = ts.Requests()
+	results, err = ra.ReadRanges(ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkResults(t, results, ranges, data)
+	if n := ts.Requests() - before; n != 1+len(ranges) {
+		t.Errorf("fallback ReadRanges made %d requests; want %d", n, 1+len(ranges))
+	}
+}
+
+func TestReadRangesErrors(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+
+	for _, ranges := range [][]Range{{{-1, 10}}, {{0, 10}, {10, -1}}} {
+		if _, err := ra.ReadRanges(ranges); err == nil {
+			t.Errorf("ReadRanges(%v) succeeded", ranges)
+		}
+	}
+
+	// ranges at and beyond the end of file
+	results, err := ra.ReadRanges([]Range{{0, 10}, {1000, 10}, {2000, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results[1:] {
+		if len(res.Data) != 0 || res.Err != io.EOF {
+			t.Errorf("result %d = %d bytes, %v; want 0 bytes, EOF", i+1, len(res.Data), res.Err)
+		}
+	}
+
+	// errors reading a range are reported in its Result
+	ts.Close()
+	results, err = ra.ReadRanges([]Range{{0, 10}, {500, 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		if res.Err == nil || res.Err == io.EOF {
+			t.Errorf("result %d error = %v; want a read error", i, res.Err)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package httpreaderat
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Option is a functional option which can be passed to New for altering
+// the default behavior of HTTPReaderAt.
+type Option func(ra *HTTPReaderAt)
+
+// WithCaptureCookies enables capturing the cookies set by the server in
+// the response to the initial request made by New. The captured cookies
+// are sent with all subsequent requests. This is useful with servers which
+// issue a session cookie on first contact. If the http.Client has a cookie
+// Jar, the cookies are managed by the Jar instead and nothing is captured.
+func WithCaptureCookies(capture bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.captureCookies = capture
+	}
+}
+
+// WithBlockCache enables caching of the remote file contents in memory.
+// The file is fetched in blocks of blockSize bytes and up to maxBlocks
+// most recently used blocks are retained (without a limit if maxBlocks is
+// not positive). Data served from the cache is not validated against the
+// server. The option is ignored if blockSize is not positive.
+func WithBlockCache(blockSize int64, maxBlocks int) Option {
+	return func(ra *HTTPReaderAt) {
+		if blockSize > 0 {
+			ra.cache = newBlockCache(blockSize, maxBlocks)
+		}
+	}
+}
+
+// WithMaxConcurrency limits the number of concurrent HTTP requests made by
+// HTTPReaderAt to n. Requests exceeding the limit wait for their turn.
+func WithMaxConcurrency(n int) Option {
+	return func(ra *HTTPReaderAt) {
+		if n > 0 {
+			ra.sem = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithProbeOpenEnded makes New probe the server with an open ended range
+// request "bytes=0-" instead of the default 1 byte range request. The size
+// of the file is determined from the Content-Range header of the response
+// even if the server reports an unknown ("*") total length. Only the
+// headers and the first byte of the response body are read; the body is
+// closed early to avoid downloading the whole file.
+func WithProbeOpenEnded(openEnded bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.probeOpenEnded = openEnded
+	}
+}
+
+// WithProbeSize makes New probe the server by requesting the first n bytes
+// of the file instead of only the first byte. The received bytes are
+// retained for the lifetime of the HTTPReaderAt and ReadAt calls falling
+// fully within them are served without making a request, which is useful
+// if the beginning of the file is always read first, for example a file
+// header. The retained data is not used with WithRevalidate. If the file is
+// smaller than n bytes, the whole file is retained.
+func WithProbeSize(n int) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.probeSize = n
+	}
+}
+
+// WithRequireKnownSize makes New fail with ErrUnknownSize if the size of
+// the remote file can not be determined, for example because the server
+// reports an unknown ("*") total length. This is useful if a known size
+// is mandatory, as with "archive/zip". Before failing, the size is
+// requested with HeadProber and UnsatisfiableRangeProber.
+func WithRequireKnownSize(require bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.requireKnownSize = require
+	}
+}
+
+// WithLiveSize allows the size of the remote file to change between
+// requests, for example when the file is being appended to. The size
+// returned by Size is updated from the total length reported by the
+// server in the Content-Range header of each response. Without this
+// option a size change results in ErrValidationFailed.
+func WithLiveSize(live bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.liveSize = live
+	}
+}
+
+// WithLocalURLs enables support for "file" and "data" URLs in the
+// prototype http.Request given to New. Such URLs are served directly from
+// the local file system or from the URL itself without making any HTTP
+// requests. This is useful for testing and for uniform handling of local
+// and remote files. It is disabled by default because accepting local
+// file URLs from untrusted sources can be a security risk.
+func WithLocalURLs(enable bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.localURLs = enable
+	}
+}
+
+// WithReadBatching enables batching of concurrent ReadAt calls. Each
+// ReadAt call is delayed by up to window to collect other ReadAt calls
+// made at the same time. Reads which are at most maxGap bytes apart from
+// each other are then served with a single range request spanning all of
+// them. This adds up to window of latency to each ReadAt call, in exchange
+// for fewer HTTP requests. Flush can be called to issue the waiting reads
+// immediately.
+func WithReadBatching(window time.Duration, maxGap int64) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.batcher = &readBatcher{
+			ra:     ra,
+			window: window,
+			maxGap: maxGap,
+		}
+	}
+}
+
+// WithPreBufferCheck sets a function which is called with the metadata of
+// the remote file before the whole file is downloaded to the Store because
+// the server does not support HTTP Range Requests. If the function returns
+// an error, the download is aborted and New returns that error. This can
+// be used to reject too large files or unexpected content types before
+// committing to the download.
+func WithPreBufferCheck(check func(meta Meta) error) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.preBufferCheck = check
+	}
+}
+
+// WithExpectedContentType makes New verify that the Content-Type of the
+// remote file matches mediaType, for example "application/zip". Media
+// type parameters such as charset are ignored. A wildcard subtype such as
+// "application/*" matches any subtype. New returns ErrUnexpectedContentType
+// if the Content-Type does not match.
+func WithExpectedContentType(mediaType string) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.expectedType = mediaType
+	}
+}
+
+// WithAutoStore enables the fallback mechanism even if no Store is given to
+// New. If the server does not support HTTP Range Requests, a Store is
+// created with NewDefaultStore and the file is buffered to it, instead of
+// failing with ErrNoRange. The Store is closed by calling Close on the
+// HTTPReaderAt.
+func WithAutoStore(enable bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.autoStore = enable
+	}
+}
+
+// WithCancelBuffering allows aborting the download of the file to the
+// Store if the server does not support HTTP Range Requests. Closing the
+// cancel channel while the download is in progress (for example from
+// a progress callback or a timer) promptly aborts it, closes the Store to
+// free the partially buffered data and makes New return
+// ErrBufferingCanceled.
+func WithCancelBuffering(cancel <-chan struct{}) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.cancelBuffering = cancel
+	}
+}
+
+// WithProber sets a custom strategy for probing the remote file during New
+// instead of the default 1 byte Range Request. See RangeGetProber and
+// HeadProber.
+func WithProber(p Prober) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.prober = p
+	}
+}
+
+// WithOperationDeadline bounds the total time taken by a single ReadAt
+// call to d, covering all HTTP requests made on behalf of that call. If
+// the deadline is exceeded, ReadAt returns context.DeadlineExceeded.
+func WithOperationDeadline(d time.Duration) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.opTimeout = d
+	}
+}
+
+// WithAcceptEncoding sets the "Accept-Encoding" header of the probe
+// request made by New and of the data requests made by ReadAt
+// independently. An empty string leaves the header as it is in the
+// prototype request (by default http.Transport does not request
+// compression for Range Requests).
+//
+// The data requests should normally use "identity": a compressed
+// partial response body does not correspond to the requested byte range
+// of the file, and ReadAt fails with ErrRangeEncoded. The probe only
+// needs the response headers, so compression may be allowed for it (for
+// example with HeadProber). If the server does not support Range
+// Requests, the compressed body of the "200 OK" response is not buffered
+// to the Store; the file is requested again with "identity" instead, at
+// the cost of an extra request.
+func WithAcceptEncoding(probe, data string) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.probeEncoding = probe
+		ra.dataEncoding = data
+	}
+}
+
+// WithTracer sets a Tracer for tracing ReadAt calls (SpanReadAt), the
+// individual HTTP requests made by them (SpanRequest) and each attempt of
+// the requests, including the retries enabled with WithRetry
+// (SpanAttempt). The Tracer can be bridged to OpenTelemetry or a similar
+// tracing system.
+func WithTracer(t Tracer) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.tracer = t
+	}
+}
+
+// WithRangeEncoder sets a function which encodes the requested byte range
+// first-last (inclusive) onto the request, instead of the default "Range"
+// header. This allows using non-standard APIs which take the range for
+// example as query parameters. Such servers must respond with exactly the
+// requested bytes. If the response does not have a "Content-Range" header,
+// the size of the file is unknown and Size returns -1.
+func WithRangeEncoder(encode func(req *http.Request, first, last int64)) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.rangeEncoder = encode
+	}
+}
+
+// WithStrictProbe enables strict validation of the probe response in New.
+// If the server responds with partial content but the Content-Range does
+// not exactly match the requested range, or its total length is smaller
+// than the range, range support is considered unreliable and New returns
+// ErrInconsistentProbe instead of recording a bogus size.
+func WithStrictProbe(strict bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.strictProbe = strict
+	}
+}
+
+// WithDiskSpaceCheck enables checking the free space of the temporary
+// file directory before the file is buffered to a StoreFile because the
+// server does not support HTTP Range Requests. If the size of the file is
+// known and the free space (minus a safety margin) is smaller than it, New
+// fails with ErrInsufficientDiskSpace before downloading. The check is
+// skipped if the size is unknown, if the Store does not use a temporary
+// file for a file of that size (for example because it fits in the memory
+// tier of NewDefaultStore) or if free space can not be determined on the
+// platform.
+func WithDiskSpaceCheck(check bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.diskSpaceCheck = check
+	}
+}
+
+// WithRevalidate makes the Range Requests conditional with an "If-Range"
+// header carrying the strong ETag or, if there is none, the Last-Modified
+// time of the remote file as seen by New. "If-Modified-Since" is not used
+// because a "304 Not Modified" response carries no data. The responses
+// are handled as follows:
+//
+//	206 Partial Content: the file is unchanged and the data is used after
+//	the usual validation of the metadata.
+//	200 OK: the condition failed because the file has changed, so
+//	ErrValidationFailed is returned without reading the body.
+//	304 Not Modified: not expected for If-Range; it is returned as an
+//	HTTPError like any other unexpected status.
+//
+// The header is not sent if the server provided neither a strong ETag nor
+// Last-Modified.
+func WithRevalidate(revalidate bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.revalidate = revalidate
+	}
+}
+
+// WithStrictEOF makes ReadAt return io.ErrUnexpectedEOF instead of io.EOF
+// if the body of a response ends before the range given in its
+// Content-Range header has been received. This allows telling a truncated
+// response, which may be worth retrying, apart from the end of the file.
+// By default io.ErrUnexpectedEOF is reported as io.EOF.
+func WithStrictEOF(strict bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.strictEOF = strict
+	}
+}
+
+// WithResumableReads makes ReadAt resume a response which is truncated
+// before the whole requested range has been received, for example because
+// the connection was reset. The data received so far is kept and the
+// missing tail of the range is requested again, up to three times. The
+// resumed responses are validated as usual.
+func WithResumableReads(resumable bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.resumableReads = resumable
+	}
+}
+
+// WithReadObserver sets a function which is called after each successful
+// ReadAt call with the offset and the data delivered to the caller,
+// whether it came from the network, the block cache or the Store. It is
+// also called if ReadAt returns io.EOF with some data. The observer can
+// be used for example for computing checksums or populating an external
+// cache. p is the buffer of the caller: the observer must not modify it
+// or retain it after returning. The observer may be called concurrently.
+func WithReadObserver(observer func(off int64, p []byte)) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.observer = observer
+	}
+}
+
+// WithRefreshSizeOnEOF allows reading data appended to the remote file
+// after New was called, for example from a growing log file. If a ReadAt
+// call extends beyond the end of the file as currently known, the server
+// is asked for the current size with an additional Range Request and the
+// read is served from the new data if the file has grown. The size and
+// Last-Modified may change when the file grows, but a changed ETag still
+// results in ErrValidationFailed, as does shrinking unless WithLiveSize is
+// enabled.
+func WithRefreshSizeOnEOF(refresh bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.refreshSizeOnEOF = refresh
+	}
+}
+
+// WithCheckRedirect sets the redirect policy used for the requests, as
+// the CheckRedirect field of http.Client does. The policy is set on a copy
+// of the http.Client given to New; the original is not modified. Without
+// this option the policy of the http.Client is used, or if it has none,
+// the http.Client default of following up to 10 redirects. Failures of
+// the policy, such as redirect loops, are reported as RedirectError.
+func WithCheckRedirect(check func(req *http.Request, via []*http.Request) error) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.checkRedirect = check
+	}
+}
+
+// WithFollowPermanentRedirects makes HTTPReaderAt remember permanent
+// redirects ("301 Moved Permanently" and "308 Permanent Redirect"): once
+// a request, for example the probe made by New, has been permanently
+// redirected, the following requests are made directly to the new
+// location. Temporary redirects ("302 Found" and "307 Temporary Redirect")
+// are followed on every request as usual. If the new location is on
+// another host, the "Authorization" and "Cookie" headers of the prototype
+// request are no longer sent, as http.Client does when following
+// redirects.
+func WithFollowPermanentRedirects(follow bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.followPermanent = follow
+	}
+}
+
+// WithURLRefresher sets a function which is called to obtain a new
+// prototype request if the server responds to a Range Request with
+// "403 Forbidden", which typically means that a presigned URL (for
+// example of Amazon S3 or Google Cloud Storage) has expired. The request
+// is then retried once with the new prototype request, which is also used
+// for all subsequent requests. The headers of the new request replace the
+// original ones, including any cookies captured with WithCaptureCookies.
+// The function may be called concurrently from concurrent ReadAt calls.
+func WithURLRefresher(refresh func(ctx context.Context) (*http.Request, error)) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.urlRefresher = refresh
+	}
+}
+
+// WithRetry makes each HTTP request to be attempted up to maxAttempts
+// times if it fails with a transport error or with a "429 Too Many
+// Requests" or 5xx status (unless a different predicate is given with
+// WithRetryPredicate). The delay before the first retry is baseDelay and
+// it is doubled for each following retry.
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.retryAttempts = maxAttempts
+		ra.retryDelay = baseDelay
+	}
+}
+
+// WithRetryPredicate sets the function which decides whether a request is
+// retried when WithRetry is enabled. It replaces the default predicate
+// entirely: it is called with the response and the error of each attempt
+// and the request is retried if it returns true. If err is non-nil, resp
+// is usually nil; otherwise resp is the response with an unread body. It
+// has no effect without WithRetry.
+func WithRetryPredicate(retry func(resp *http.Response, err error) bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.retryPredicate = retry
+	}
+}
+
+// WithAutoBufferThreshold makes HTTPReaderAt switch to buffering the whole
+// remote file to the Store once the data fetched with Range Requests
+// exceeds fraction times the size of the file. This helps with access
+// patterns which turn out to read most of the file anyway, at the cost of
+// downloading the file once more in full. The download is made by the
+// ReadAt call which crosses the threshold, within its context and
+// WithOperationDeadline; subsequent ReadAt calls are served from the
+// Store. It requires a Store or a StoreFactory (or
+// WithAutoStore) and a known size; it is attempted only once and Range
+// Requests continue to be used if it fails.
+func WithAutoBufferThreshold(fraction float64) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.autoBufferFraction = fraction
+	}
+}
+
+// WithStrictBodyLength makes ReadAt return ErrBodyTooLong if the body of
+// a response continues after the range given in its Content-Range header.
+// By default the extra bytes are silently discarded.
+func WithStrictBodyLength(strict bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.strictBodyLength = strict
+	}
+}
+
+// WithStrictContentLength makes New and ReadAt return
+// ErrContentLengthMismatch if the length of a response body differs from
+// its "Content-Length" header. This applies both to the download of the
+// whole file to the Store and to partial responses, where the
+// "Content-Length" must also match the range given in the
+// "Content-Range" header. By default the mismatch is ignored: the
+// "Content-Range" header is the authority on the body length of partial
+// responses and the downloaded size of the file is used as is.
+func WithStrictContentLength(strict bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.strictContentLength = strict
+	}
+}
+
+// WithBackoff sets the schedule of delays between the retries enabled with
+// WithRetry, replacing the default ExponentialBackoff starting from the
+// base delay given to WithRetry.
+func WithBackoff(b Backoff) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.backoff = b
+	}
+}
+
+// WithValidationInterval makes HTTPReaderAt validate the metadata of the
+// responses (see ReadAt) at most once per interval d, trusting that the
+// remote file has not changed in between. The size reported in the
+// Content-Range header is still checked on every response. It has no
+// effect together with WithRefreshSizeOnEOF, which requires validating
+// every response.
+func WithValidationInterval(d time.Duration) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.validationInterval = d
+	}
+}
+
+// RangeHeaderFormat is the format of the "Range" header, see
+// WithRangeHeaderFormat.
+type RangeHeaderFormat int
+
+const (
+	// RFCInclusive is the standard format "bytes=first-last" where last
+	// is the offset of the last byte of the range (RFC 7233).
+	RFCInclusive RangeHeaderFormat = iota
+	// StartLength is the non-standard format "bytes=first+length".
+	StartLength
+)
+
+// WithRangeHeaderFormat sets the format of the "Range" header. It is a
+// shorthand for the common cases of WithRangeEncoder, which it replaces;
+// RFCInclusive restores the default behavior. With StartLength, the
+// response is validated against its "Content-Range" header, which is
+// expected in the standard format, if there is one. Without it, the server
+// must respond with exactly the requested bytes and Size returns -1.
+func WithRangeHeaderFormat(format RangeHeaderFormat) Option {
+	return func(ra *HTTPReaderAt) {
+		switch format {
+		case StartLength:
+			ra.rangeEncoder = encodeStartLength
+		default:
+			ra.rangeEncoder = nil
+		}
+	}
+}
+
+// WithRangeHeaderName sets the name of the header used for the requested
+// range instead of "Range", for backends which use a non-standard name
+// (for example "x-ms-range" of Azure Blob Storage) but are otherwise
+// compliant with RFC 7233. The value of the header, as well as the
+// "Content-Range" header of the responses, is in the standard format.
+// The header set by WithRangeEncoder and by the Probers is not affected.
+func WithRangeHeaderName(name string) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.rangeHeader = name
+	}
+}
+
+func encodeStartLength(req *http.Request, first, last int64) {
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d+%d", first, last-first+1))
+}
+
+// WithETagOptional relaxes the validation done by ReadAt so that a changed
+// ETag alone does not result in ErrValidationFailed if the size and the
+// Last-Modified time are unchanged. This helps with origins consisting of
+// several servers generating different ETags for the same content. If the
+// server does not send Last-Modified, the ETag is still compared.
+func WithETagOptional(optional bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.etagOptional = optional
+	}
+}
+
+// WithTransport makes HTTPReaderAt use a copy of the http.Client given to
+// New (or http.DefaultClient) with rt as the Transport. The original
+// client is not modified. This allows tuning the connection handling per
+// HTTPReaderAt. For workloads making many Range Requests to the same host,
+// for example concurrent ReadAt calls, an *http.Transport with
+// MaxIdleConnsPerHost at least as large as the expected concurrency
+// avoids reconnecting for each request.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(ra *HTTPReaderAt) {
+		c := *ra.client
+		c.Transport = rt
+		ra.client = &c
+	}
+}
+
+// WithFallbackDecider sets a function which decides, based on the response
+// to the probe request made by New, whether the whole file is buffered
+// to the Store instead of using Range Requests. By default the file is
+// buffered if the server responds with "200 OK". The decider allows
+// handling servers with quirks, for example ones which respond with
+// "206 Partial Content" and the whole file as the range: if decide
+// returns true, the body of the response is buffered to the Store as the
+// whole file; New fails if its "Content-Range" does not cover the whole
+// file ("bytes 0-(length-1)/length"). If it returns false for a "200 OK"
+// response, New fails with NoRangeError. A Store or a StoreFactory is
+// needed for buffering.
+func WithFallbackDecider(decide func(resp *http.Response) bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.fallbackDecider = decide
+	}
+}
+
+// WithBufferPool makes HTTPReaderAt obtain its internal scratch buffers
+// from pool instead of allocating them, to reduce allocations under high
+// throughput. The scratch buffers are used for example for the merged
+// requests of WithReadBatching, by WarmUp and for the parts of
+// multipart/byteranges responses in ReadRanges. The buffers given by the
+// caller to ReadAt are still used as is.
+func WithBufferPool(pool BufferPool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.bufPool = pool
+	}
+}
+
+// WithDisableFallback disables the fallback mechanism of buffering the
+// whole file to the Store even if a Store or a StoreFactory is given. If
+// the server does not support HTTP Range Requests, New fails with
+// NoRangeError, and if it stops supporting them later, ReadAt fails with
+// ErrRangeSupportLost, as without a Store. WithAutoBufferThreshold is not
+// affected.
+func WithDisableFallback(disable bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.disableFallback = disable
+	}
+}
+
+// WithCheckTotalLength makes every partial response be checked more
+// strictly for a changed size of the remote file. The total length in the
+// "Content-Range" header of each response is compared to the cached size,
+// also by CopyRange, ReadToEnd and when the validation is skipped because
+// of WithValidationInterval, and a response with an unknown total length
+// ("bytes 0-99/*") is rejected. A mismatch results in ErrValidationFailed.
+// This detects servers changing the file without changing the "ETag" and
+// "Last-Modified" headers. With WithLiveSize a changed total length updates
+// the cached size instead. Responses without "Content-Range" allowed by
+// WithRangeEncoder are not affected.
+func WithCheckTotalLength(check bool) Option {
+	return func(ra *HTTPReaderAt) {
+		ra.checkTotal = check
+	}
+}
@@ -0,0 +1,287 @@
+package httpreaderat
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Prober is the interface to a strategy for probing the remote file
+// during New. Probe makes one or more requests using do, based on the
+// prototype request req, and returns the metadata of the remote file
+// along with the data (if any) retrieved from the beginning of the file.
+// The data is served by ReadAt without making a request, unless a probe
+// encoding is set with WithAcceptEncoding. If the returned Meta has
+// AcceptRanges set to false, New falls back to the default probe which
+// engages the Store fallback mechanism.
+type Prober interface {
+	Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error)
+}
+
+// RangeGetProber probes the remote file with a GET Range Request for the
+// first Length bytes of the file. Length defaults to 1. A larger Length
+// retrieves more data with the probe.
+type RangeGetProber struct {
+	Length int64
+}
+
+var _ Prober = RangeGetProber{}
+
+// Probe implements the Prober interface.
+func (p RangeGetProber) Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error) {
+	length := p.Length
+	if length < 1 {
+		length = 1
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", length-1))
+
+	resp, err := do(req)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return getMeta(resp), nil, nil
+	case http.StatusPartialContent:
+	default:
+		return Meta{}, nil, newHTTPError(resp)
+	}
+	meta := getMeta(resp)
+	first, last, _, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil || first != 0 || last < 0 || last > length-1 {
+		return Meta{}, nil, errProbeRange
+	}
+	data := make([]byte, last+1)
+	n, err := io.ReadFull(resp.Body, data)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	return meta, data[:n], nil
+}
+
+// HeadProber probes the remote file with a HEAD request. Range Request
+// support is determined from the "Accept-Ranges" response header.
+type HeadProber struct{}
+
+var _ Prober = HeadProber{}
+
+// Probe implements the Prober interface.
+func (HeadProber) Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error) {
+	req = req.WithContext(ctx)
+	req.Method = "HEAD"
+
+	resp, err := do(req)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Meta{}, nil, newHTTPError(resp)
+	}
+	meta := getMeta(resp)
+	meta.AcceptRanges = strings.EqualFold(
+		strings.TrimSpace(resp.Header.Get("Accept-Ranges")), "bytes")
+	return meta, nil, nil
+}
+
+// UnsatisfiableRangeProber discovers the size of the remote file with
+// a deliberately unsatisfiable Range Request. Some servers only reveal the
+// size in the Content-Range header ("bytes */12345") of the resulting
+// "416 Range Not Satisfiable" response.
+type UnsatisfiableRangeProber struct{}
+
+var _ Prober = UnsatisfiableRangeProber{}
+
+// Probe implements the Prober interface.
+func (UnsatisfiableRangeProber) Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error) {
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", int64(math.MaxInt64)))
+
+	resp, err := do(req)
+	if err != nil {
+		return Meta{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusRequestedRangeNotSatisfiable:
+	case http.StatusOK:
+		return getMeta(resp), nil, nil
+	default:
+		return Meta{}, nil, newHTTPError(resp)
+	}
+	meta := getMeta(resp)
+	_, _, meta.Size, err = parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return Meta{}, nil, errProbeRange
+	}
+	meta.AcceptRanges = true
+	return meta, nil, nil
+}
+
+// ProberChain tries the Probers in order until one of them determines the
+// size of the remote file. The result of the last Prober is returned if
+// none of them does.
+type ProberChain []Prober
+
+var _ Prober = ProberChain{}
+
+// Probe implements the Prober interface.
+func (pc ProberChain) Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (meta Meta, data []byte, err error) {
+	for _, p := range pc {
+		meta, data, err = p.Probe(ctx, do, cloneRequest(req))
+		if err == nil && (meta.Size != -1 || !meta.AcceptRanges) {
+			break
+		}
+	}
+	return meta, data, err
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	out := *req
+	u := *req.URL
+	out.URL = &u
+	out.Header = cloneHeader(req.Header)
+	return &out
+}
+
+// probe runs the Prober given with WithProber. It returns true if the
+// probe succeeded and the default probe is not needed.
+func (ra *HTTPReaderAt) probe(ctx context.Context) (ok bool, err error) {
+	req := ra.copyReq()
+	if ra.probeEncoding != "" {
+		req.Header.Set("Accept-Encoding", ra.probeEncoding)
+	}
+	do := func(req *http.Request) (*http.Response, error) {
+		resp, err := ra.do(req)
+		if err == nil {
+			ra.resolvedURL = resp.Request.URL
+		}
+		return resp, err
+	}
+	meta, data, err := ra.prober.Probe(ctx, do, req)
+	if err != nil {
+		return false, err
+	}
+	if !meta.AcceptRanges {
+		return false, nil
+	}
+	ra.meta = meta
+	if meta.Size != -1 && int64(len(data)) > meta.Size {
+		data = data[:meta.Size]
+	}
+	if ra.probeEncoding == "" {
+		// with WithAcceptEncoding the data may be content encoded
+		ra.probeData = data
+	}
+	return true, ra.checkContentType()
+}
+
+// do makes a HTTP request with the client of the HTTPReaderAt.
+func (ra *HTTPReaderAt) do(req *http.Request) (*http.Response, error) {
+	resp, err := ra.doRetry(req)
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			if re, ok := ue.Err.(*RedirectError); ok {
+				if resp != nil {
+					resp.Body.Close()
+				}
+				return nil, re
+			}
+		}
+		return nil, redactError(err)
+	}
+	if ra.followPermanent {
+		ra.followPermanentRedirects(resp)
+	}
+	return resp, nil
+}
+
+// followPermanentRedirects replaces the URL of the prototype request with
+// the location the request was permanently redirected to, as with
+// WithFollowPermanentRedirects. Only the leading permanent redirects
+// ("301 Moved Permanently" and "308 Permanent Redirect") of the redirect
+// chain of resp are taken into account.
+func (ra *HTTPReaderAt) followPermanentRedirects(resp *http.Response) {
+	// redirect responses from the last one to the first one
+	var hops []*http.Response
+	for r := resp.Request.Response; r != nil; r = r.Request.Response {
+		hops = append(hops, r)
+	}
+	var target *url.URL
+	for i := len(hops) - 1; i >= 0; i-- {
+		if code := hops[i].StatusCode; code != http.StatusMovedPermanently &&
+			code != http.StatusPermanentRedirect {
+			break
+		}
+		if i == 0 {
+			target = resp.Request.URL
+		} else {
+			target = hops[i-1].Request.URL
+		}
+	}
+	if target == nil {
+		return
+	}
+	ra.reqMu.Lock()
+	defer ra.reqMu.Unlock()
+
+	req := *ra.req
+	u := *target
+	req.URL = &u
+	req.Host = ""
+	if !strings.EqualFold(ra.req.URL.Host, u.Host) {
+		// as http.Client does, do not send credentials to another host
+		header := cloneHeader(ra.header)
+		for _, k := range []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"} {
+			header.Del(k)
+		}
+		ra.header = header
+	}
+	ra.req = &req
+}
+
+// maxRedirects is the number of redirects followed by default, the same
+// as with http.Client.
+const maxRedirects = 10
+
+// setCheckRedirect makes ra use a copy of its client with a redirect
+// policy which reports failures as RedirectError. The policy given with
+// WithCheckRedirect is used if any, otherwise the one of the client or
+// the http.Client default policy.
+func (ra *HTTPReaderAt) setCheckRedirect() {
+	check := ra.checkRedirect
+	if check == nil {
+		check = ra.client.CheckRedirect
+	}
+	if check == nil {
+		check = func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		}
+	}
+	c := *ra.client
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		err := check(req, via)
+		if err == nil || err == http.ErrUseLastResponse {
+			return err
+		}
+		return &RedirectError{
+			URL:       redactURL(req.URL),
+			Redirects: len(via),
+			Err:       err,
+		}
+	}
+	ra.client = &c
+}
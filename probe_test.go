@@ -0,0 +1,77 @@
+package httpreaderat
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestHeadProber(t *testing.T) {
+	data := makeTestData(1000)
+	for _, tt := range []struct {
+		acceptRanges bool
+		wantMethods  []string
+	}{
+		{true, []string{"HEAD", "GET"}},
+		// the default probe is made if Range Requests are not announced
+		{false, []string{"HEAD", "GET", "GET"}},
+	} {
+		h := &rangeHandler{data: data}
+		var mu sync.Mutex
+		var methods []string
+		ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			methods = append(methods, r.Method)
+			mu.Unlock()
+			if tt.acceptRanges {
+				w.Header().Set("Accept-Ranges", "bytes")
+			}
+			h.ServeHTTP(w, r)
+		})
+		ra := newTestReaderAt(t, ts.URL, nil, WithProber(HeadProber{}))
+		if ra.Size() != int64(len(data)) {
+			t.Errorf("accept ranges %v: Size = %d; want %d", tt.acceptRanges, ra.Size(), len(data))
+		}
+		readAndCheck(t, ra, data, 100, 100)
+		mu.Lock()
+		if !reflect.DeepEqual(methods, tt.wantMethods) {
+			t.Errorf("accept ranges %v: requests %v; want %v", tt.acceptRanges, methods, tt.wantMethods)
+		}
+		mu.Unlock()
+	}
+}
+
+// proberFunc is a Prober implemented by a function.
+type proberFunc func(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error)
+
+func (f proberFunc) Probe(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error) {
+	return f(ctx, do, req)
+}
+
+func TestCustomProber(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	// a speculative 200 byte probe followed by a HEAD for the size
+	p := proberFunc(func(ctx context.Context, do func(*http.Request) (*http.Response, error), req *http.Request) (Meta, []byte, error) {
+		_, b, err := RangeGetProber{Length: 200}.Probe(ctx, do, cloneRequest(req))
+		if err != nil {
+			return Meta{}, nil, err
+		}
+		meta, _, err := HeadProber{}.Probe(ctx, do, req)
+		meta.AcceptRanges = true
+		return meta, b, err
+	})
+	ra := newTestReaderAt(t, ts.URL, nil, WithProber(p))
+	if ts.Requests() != 2 {
+		t.Errorf("probe requests = %d; want 2", ts.Requests())
+	}
+	if ra.Size() != int64(len(data)) {
+		t.Errorf("Size = %d; want %d", ra.Size(), len(data))
+	}
+	readAndCheck(t, ra, data, 0, 200)
+	if ts.Requests() != 2 {
+		t.Error("the data of the probe is not served")
+	}
+}
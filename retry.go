@@ -0,0 +1,125 @@
+package httpreaderat
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Backoff is the interface to a schedule of delays between retries.
+// NextDelay returns the delay before retry number attempt, starting at 1.
+type Backoff interface {
+	NextDelay(attempt int) time.Duration
+}
+
+// ExponentialBackoff is a Backoff with the delay of Base before the first
+// retry, doubled for each following retry. If Max is positive, the delay
+// is capped at Max.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+var _ Backoff = ExponentialBackoff{}
+
+// NextDelay implements the Backoff interface.
+func (b ExponentialBackoff) NextDelay(attempt int) time.Duration {
+	d := b.Base
+	for i := 1; i < attempt && d > 0 && d <= math.MaxInt64/2; i++ {
+		d *= 2
+	}
+	if b.Max > 0 && d > b.Max {
+		d = b.Max
+	}
+	return d
+}
+
+// ConstantBackoff is a Backoff with the same delay before every retry.
+type ConstantBackoff struct {
+	Delay time.Duration
+}
+
+var _ Backoff = ConstantBackoff{}
+
+// NextDelay implements the Backoff interface.
+func (b ConstantBackoff) NextDelay(attempt int) time.Duration {
+	return b.Delay
+}
+
+// defaultRetryPredicate reports whether a request should be retried when
+// WithRetry is enabled and no predicate is given with WithRetryPredicate:
+// on transport errors other than redirect policy failures, "429 Too Many
+// Requests" and 5xx server errors.
+func defaultRetryPredicate(resp *http.Response, err error) bool {
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			if _, ok := ue.Err.(*RedirectError); ok {
+				return false
+			}
+		}
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode >= 500
+}
+
+// doRetry makes the request like client.Do, retrying as configured with
+// WithRetry and WithRetryPredicate. The delays before the retries are
+// given by the Backoff set with WithBackoff, by default doubling starting
+// from the base delay given to WithRetry. The request context aborts the
+// waiting. Each attempt is traced as a SpanAttempt.
+func (ra *HTTPReaderAt) doRetry(req *http.Request) (resp *http.Response, err error) {
+	retry := ra.retryPredicate
+	if retry == nil {
+		retry = defaultRetryPredicate
+	}
+	backoff := ra.backoff
+	if backoff == nil {
+		backoff = ExponentialBackoff{Base: ra.retryDelay}
+	}
+	ctx := req.Context()
+	for attempt := 1; ; attempt++ {
+		resp, err = ra.doAttempt(req)
+		if attempt >= ra.retryAttempts || ctx.Err() != nil || !retry(resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err = sleep(ctx, backoff.NextDelay(attempt)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// sleep waits for the duration d or until ctx is done, in which case the
+// error of ctx is returned. It is a variable so that the tests can replace
+// the clock.
+var sleep = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// doAttempt makes a single attempt of the request with client.Do, traced
+// as a SpanAttempt if a Tracer is set.
+func (ra *HTTPReaderAt) doAttempt(req *http.Request) (resp *http.Response, err error) {
+	if ra.tracer == nil {
+		return ra.client.Do(req)
+	}
+	ctx, endSpan := ra.tracer.StartSpan(req.Context(), SpanAttempt)
+	resp, err = ra.client.Do(req.WithContext(ctx))
+	var span SpanInfo
+	if resp != nil {
+		span.StatusCode = resp.StatusCode
+	}
+	endSpan(span, err)
+	return resp, err
+}
@@ -0,0 +1,166 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// recordSleeps replaces sleep for the duration of the test with a function
+// which returns immediately and records the delays.
+func recordSleeps(t *testing.T) *[]time.Duration {
+	var delays []time.Duration
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	t.Cleanup(func() { sleep = orig })
+	return &delays
+}
+
+func equalDelays(a, b []time.Duration) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		b    ExponentialBackoff
+		want []time.Duration
+	}{
+		{ExponentialBackoff{Base: 100 * ms}, []time.Duration{100 * ms, 200 * ms, 400 * ms, 800 * ms}},
+		{ExponentialBackoff{Base: 100 * ms, Max: 300 * ms}, []time.Duration{100 * ms, 200 * ms, 300 * ms, 300 * ms}},
+		{ExponentialBackoff{}, []time.Duration{0, 0, 0}},
+	}
+	for _, tt := range tests {
+		var got []time.Duration
+		for attempt := 1; attempt <= len(tt.want); attempt++ {
+			got = append(got, tt.b.NextDelay(attempt))
+		}
+		if !equalDelays(got, tt.want) {
+			t.Errorf("%+v: delays = %v; want %v", tt.b, got, tt.want)
+		}
+	}
+
+	// the doubling stops before overflowing
+	b := ExponentialBackoff{Base: time.Second}
+	if d := b.NextDelay(100); d <= 0 {
+		t.Errorf("NextDelay(100) = %v; want positive", d)
+	}
+	b.Max = time.Hour
+	if d := b.NextDelay(100); d != time.Hour {
+		t.Errorf("NextDelay(100) = %v; want %v", d, time.Hour)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	data := makeTestData(100)
+	tests := []struct {
+		name       string
+		failures   int // number of 503 responses before success
+		opts       []Option
+		wantErr    bool
+		wantDelays []time.Duration
+	}{
+		{
+			name:       "success after retries",
+			failures:   2,
+			opts:       []Option{WithRetry(4, 10*time.Millisecond)},
+			wantDelays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		{
+			name:       "exhausted",
+			failures:   10,
+			opts:       []Option{WithRetry(3, 10*time.Millisecond)},
+			wantErr:    true,
+			wantDelays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond},
+		},
+		{
+			name:     "capped",
+			failures: 4,
+			opts: []Option{
+				WithRetry(5, 10*time.Millisecond),
+				WithBackoff(ExponentialBackoff{Base: 10 * time.Millisecond, Max: 25 * time.Millisecond}),
+			},
+			wantDelays: []time.Duration{10 * time.Millisecond, 20 * time.Millisecond,
+				25 * time.Millisecond, 25 * time.Millisecond},
+		},
+		{
+			name:     "not retried",
+			failures: 1,
+			opts: []Option{
+				WithRetry(3, 10*time.Millisecond),
+				WithRetryPredicate(func(*http.Response, error) bool { return false }),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delays := recordSleeps(t)
+			failures := int32(tt.failures)
+			ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&failures, -1) >= 0 {
+					http.Error(w, "unavailable", http.StatusServiceUnavailable)
+					return
+				}
+				http.ServeContent(w, r, "", testModTime, bytes.NewReader(data))
+			})
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := New(nil, req, nil, tt.opts...)
+			if tt.wantErr {
+				if err == nil {
+					ra.Close()
+					t.Fatal("New succeeded; want error")
+				}
+				if _, ok := err.(*ProbeError); !ok {
+					t.Errorf("New error = %T %v; want *ProbeError", err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else {
+				ra.Close()
+			}
+			if !equalDelays(*delays, tt.wantDelays) {
+				t.Errorf("delays = %v; want %v", *delays, tt.wantDelays)
+			}
+			if want := len(tt.wantDelays) + 1; ts.Requests() != want {
+				t.Errorf("requests = %d; want %d", ts.Requests(), want)
+			}
+		})
+	}
+}
+
+func TestRetryCanceled(t *testing.T) {
+	ts := newTestServer(t, nil, func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	orig := sleep
+	sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return orig(ctx, time.Hour)
+	}
+	defer func() { sleep = orig }()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	_, err := NewContext(ctx, nil, req, nil, WithRetry(5, time.Hour))
+	if err == nil {
+		t.Fatal("NewContext succeeded; want error")
+	}
+	if ts.Requests() != 1 {
+		t.Errorf("requests = %d; want 1", ts.Requests())
+	}
+}
@@ -0,0 +1,136 @@
+package httpreaderat
+
+import (
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// PartSpec specifies one part of a file which is split into several
+// consecutive parts served from separate URLs.
+type PartSpec struct {
+	URL    string // URL of the part
+	Length int64  // length of the part in bytes
+}
+
+// ShardedReaderAt is io.ReaderAt implementation which presents several
+// remote part files as a single contiguous file. A HTTPReaderAt is
+// created for each part when it is accessed for the first time. It is
+// safe for concurrent use.
+type ShardedReaderAt struct {
+	client *http.Client
+	opts   []Option
+	parts  []shard
+	size   int64
+}
+
+type shard struct {
+	spec PartSpec
+	off  int64 // offset of the part within the whole file
+
+	mu sync.Mutex
+	ra *HTTPReaderAt
+}
+
+var _ io.ReaderAt = (*ShardedReaderAt)(nil)
+
+// NewShardedReaderAt creates a new ShardedReaderAt from parts in the
+// given order. The client and opts are used for creating the HTTPReaderAt
+// instances for the parts (see New). No HTTP requests are made until the
+// parts are read.
+func NewShardedReaderAt(client *http.Client, parts []PartSpec, opts ...Option) *ShardedReaderAt {
+	s := &ShardedReaderAt{
+		client: client,
+		opts:   opts,
+		parts:  make([]shard, len(parts)),
+	}
+	for i, spec := range parts {
+		s.parts[i].spec = spec
+		s.parts[i].off = s.size
+		s.size += spec.Length
+	}
+	return s
+}
+
+// Size returns the total size of all parts.
+func (s *ShardedReaderAt) Size() int64 {
+	return s.size
+}
+
+func (s *ShardedReaderAt) part(i int) (*HTTPReaderAt, error) {
+	sh := &s.parts[i]
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.ra != nil {
+		return sh.ra, nil
+	}
+	req, err := http.NewRequest("GET", sh.spec.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	ra, err := New(s.client, req, nil, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ra.Size() != -1 && ra.Size() != sh.spec.Length {
+		ra.Close()
+		return nil, ErrValidationFailed
+	}
+	sh.ra = ra
+	return ra, nil
+}
+
+// ReadAt reads len(b) bytes starting at byte offset off. Reads spanning
+// several parts are split into separate reads from each part.
+func (s *ShardedReaderAt) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	// find the first part containing off
+	i := sort.Search(len(s.parts), func(i int) bool {
+		return s.parts[i].off+s.parts[i].spec.Length > off
+	})
+	for ; n < len(p) && i < len(s.parts); i++ {
+		sh := &s.parts[i]
+		if sh.spec.Length == 0 {
+			continue
+		}
+		ra, err := s.part(i)
+		if err != nil {
+			return n, err
+		}
+		partOff := off + int64(n) - sh.off
+		buf := p[n:]
+		if rem := sh.spec.Length - partOff; int64(len(buf)) > rem {
+			buf = buf[:rem]
+		}
+		m, err := ra.ReadAt(buf, partOff)
+		n += m
+		if err != nil && !(err == io.EOF && m == len(buf)) {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Close closes the HTTPReaderAt instances created for the parts.
+func (s *ShardedReaderAt) Close() error {
+	var err error
+	for i := range s.parts {
+		sh := &s.parts[i]
+		sh.mu.Lock()
+		if sh.ra != nil {
+			if err2 := sh.ra.Close(); err2 != nil && err == nil {
+				err = err2
+			}
+			sh.ra = nil
+		}
+		sh.mu.Unlock()
+	}
+	return err
+}
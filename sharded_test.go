@@ -0,0 +1,61 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestShardedReaderAt(t *testing.T) {
+	data := makeTestData(1000)
+	lengths := []int64{300, 0, 200, 500}
+	var parts []PartSpec
+	var servers []*testServer
+	var off int64
+	for _, l := range lengths {
+		ts := newTestServer(t, data[off:off+l], nil)
+		servers = append(servers, ts)
+		parts = append(parts, PartSpec{URL: ts.URL, Length: l})
+		off += l
+	}
+	s := NewShardedReaderAt(nil, parts)
+	defer s.Close()
+	if s.Size() != int64(len(data)) {
+		t.Errorf("Size = %d; want %d", s.Size(), len(data))
+	}
+	for _, ts := range servers {
+		if ts.Requests() != 0 {
+			t.Fatal("parts accessed before reading")
+		}
+	}
+
+	// within the first part only
+	p := make([]byte, 100)
+	if n, err := s.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+		t.Errorf("ReadAt(100) = %d, %v", n, err)
+	}
+	if servers[2].Requests() != 0 || servers[3].Requests() != 0 {
+		t.Error("parts not read from were accessed")
+	}
+	// spanning all the parts
+	p = make([]byte, 900)
+	if n, err := s.ReadAt(p, 50); n != len(p) || err != nil || !bytes.Equal(p, data[50:950]) {
+		t.Errorf("ReadAt(50) = %d, %v", n, err)
+	}
+	// past the end
+	p = make([]byte, 100)
+	n, err := s.ReadAt(p, 950)
+	if n != 50 || err != io.EOF || !bytes.Equal(p[:n], data[950:]) {
+		t.Errorf("ReadAt(950) = %d, %v; want 50, EOF", n, err)
+	}
+}
+
+func TestShardedReaderAtLengthMismatch(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, nil)
+	s := NewShardedReaderAt(nil, []PartSpec{{URL: ts.URL, Length: 999}})
+	defer s.Close()
+	if _, err := s.ReadAt(make([]byte, 100), 0); err != ErrValidationFailed {
+		t.Errorf("ReadAt error = %v; want %v", err, ErrValidationFailed)
+	}
+}
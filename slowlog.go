@@ -0,0 +1,35 @@
+package httpreaderat
+
+import (
+	"io"
+	"time"
+)
+
+// slowReadLogger is io.ReaderAt decorator which calls a callback for
+// ReadAt calls taking longer than a threshold.
+type slowReadLogger struct {
+	ra        io.ReaderAt
+	threshold time.Duration
+	cb        func(off int64, n int, dur time.Duration)
+}
+
+// NewSlowReadLogger wraps ra so that cb is called for every ReadAt call
+// which takes longer than threshold. The callback gets the offset, the
+// number of bytes read and the duration of the call. The returned
+// io.ReaderAt is safe for concurrent use if ra and cb are.
+func NewSlowReadLogger(ra io.ReaderAt, threshold time.Duration, cb func(off int64, n int, dur time.Duration)) io.ReaderAt {
+	return &slowReadLogger{
+		ra:        ra,
+		threshold: threshold,
+		cb:        cb,
+	}
+}
+
+func (l *slowReadLogger) ReadAt(p []byte, off int64) (n int, err error) {
+	start := time.Now()
+	n, err = l.ra.ReadAt(p, off)
+	if dur := time.Since(start); dur > l.threshold {
+		l.cb(off, n, dur)
+	}
+	return n, err
+}
@@ -0,0 +1,39 @@
+package httpreaderat
+
+import (
+	"sync/atomic"
+)
+
+// Stats contains usage statistics of a HTTPReaderAt.
+type Stats struct {
+	ReadAtCalls          int64 // number of ReadAt calls
+	CacheHits            int64 // ReadAt calls served fully from the block cache
+	NetworkReads         int64 // HTTP requests made
+	BytesFetched         int64 // bytes received from the network
+	BytesServedFromCache int64 // bytes served from the block cache
+}
+
+// counters holds the statistics. It is allocated separately in order to
+// guarantee 64-bit alignment for atomic operations on 32-bit platforms.
+type counters struct {
+	readAtCalls          int64
+	cacheHits            int64
+	networkReads         int64
+	bytesFetched         int64
+	bytesServedFromCache int64
+
+	lastValidated int64 // time of the last validation, see WithValidationInterval
+}
+
+// Stats returns a snapshot of the usage statistics. Each counter is
+// updated atomically.
+func (ra *HTTPReaderAt) Stats() Stats {
+	c := ra.counters
+	return Stats{
+		ReadAtCalls:          atomic.LoadInt64(&c.readAtCalls),
+		CacheHits:            atomic.LoadInt64(&c.cacheHits),
+		NetworkReads:         atomic.LoadInt64(&c.networkReads),
+		BytesFetched:         atomic.LoadInt64(&c.bytesFetched),
+		BytesServedFromCache: atomic.LoadInt64(&c.bytesServedFromCache),
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"sync"
 )
 
 // Store is the interface to a temporary byte storage. Calling ReadFrom
@@ -24,39 +25,182 @@ type Store interface {
 // 1 MB in memory and if that is exceeded, up to 1 GB to a temporary file.
 // Returned Store must be Closed if it is no longer needed.
 func NewDefaultStore() Store {
+	s, _ := NewDefaultStoreWithLimits(1024*1024, 1024*1024*1024)
+	return s
+}
+
+// NewDefaultStoreWithLimits creates a Store like NewDefaultStore, but with
+// the specified limits. It buffers up to memLimit bytes in memory and if
+// that is exceeded, up to fileLimit bytes to a temporary file. Both limits
+// must be positive and memLimit must not exceed fileLimit.
+// Returned Store must be Closed if it is no longer needed.
+func NewDefaultStoreWithLimits(memLimit, fileLimit int64) (Store, error) {
+	if memLimit <= 0 || fileLimit <= 0 || memLimit > fileLimit {
+		return nil, errors.New("invalid store limits")
+	}
 	return NewLimitedStore(
-		NewStoreMemory(), 1024*1024, NewLimitedStore(
-			NewStoreFile(), 1024*1024*1024, nil))
+		NewStoreMemory(), memLimit, NewLimitedStore(
+			NewStoreFile(), fileLimit, nil)), nil
+}
+
+// File is the interface to a temporary file used by StoreFile.
+// It is implemented by *os.File.
+type File interface {
+	io.ReaderAt
+	io.Writer
+	io.Seeker
+	io.Closer
+	Name() string
+	Sync() error
+	Truncate(size int64) error
+}
+
+// FileSystem is the interface to a file system used by StoreFile for
+// creating and removing temporary files.
+type FileSystem interface {
+	// CreateTemp creates a new temporary file as ioutil.TempFile does.
+	CreateTemp(dir, pattern string) (File, error)
+	// Remove removes the named file.
+	Remove(name string) error
+}
+
+// OSFileSystem is a FileSystem backed by the operating system. It is the
+// default FileSystem used by StoreFile.
+type OSFileSystem struct{}
+
+var _ FileSystem = OSFileSystem{}
+
+// CreateTemp creates a new temporary file with ioutil.TempFile.
+func (OSFileSystem) CreateTemp(dir, pattern string) (File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Remove removes the named file with os.Remove.
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
 }
 
 // StoreFile takes data from io.Reader and provides io.ReaderAt backed by
 // a temporary file. It implements the Store interface.
 type StoreFile struct {
-	tmpfile *os.File
-	size    int64
+	tmpfile   File
+	size      int64
+	fsys      FileSystem
+	dir       string
+	prefix    string
+	syncEvery int64
+	fixed     bool // tmpfile was given by the caller
+	keep      bool // tmpfile is not removed by Close
 }
 
 var _ Store = (*StoreFile)(nil)
 
 func NewStoreFile() *StoreFile {
-	return &StoreFile{}
+	return &StoreFile{prefix: "gotmp"}
+}
+
+// NewStoreFileWithOptions creates a StoreFile which creates its temporary
+// file in directory dir with name beginning with prefix (see
+// ioutil.TempFile). If syncEvery is positive, the temporary file is synced
+// to disk every time syncEvery bytes have been written to it, bounding the
+// amount of dirty pages in the page cache. If syncEvery is zero, the file
+// is never explicitly synced.
+func NewStoreFileWithOptions(dir, prefix string, syncEvery int64) *StoreFile {
+	return &StoreFile{
+		dir:       dir,
+		prefix:    prefix,
+		syncEvery: syncEvery,
+	}
+}
+
+// NewStoreFileFS creates a StoreFile which creates its temporary file
+// using the FileSystem fsys in directory dir with name beginning with
+// prefix.
+func NewStoreFileFS(fsys FileSystem, dir, prefix string) *StoreFile {
+	return &StoreFile{
+		fsys:   fsys,
+		dir:    dir,
+		prefix: prefix,
+	}
+}
+
+// NewStoreFileFromFile creates a StoreFile which uses the already opened
+// file f instead of creating a temporary file, for example when the
+// process is not allowed to create files. ReadFrom truncates f and writes
+// to it from the beginning. Close closes f and, if deleteOnClose is true,
+// removes it. The StoreFile can not be reused after Close.
+func NewStoreFileFromFile(f *os.File, deleteOnClose bool) *StoreFile {
+	return &StoreFile{
+		tmpfile: f,
+		fixed:   true,
+		keep:    !deleteOnClose,
+	}
+}
+
+func (s *StoreFile) fs() FileSystem {
+	if s.fsys == nil {
+		return OSFileSystem{}
+	}
+	return s.fsys
 }
 
 // Read and store the contents of r to a temporary file. Previous contents
-// (if any) are erased. Can not be called concurrently.
+// (if any) are erased. The temporary file is truncated and reused if
+// ReadFrom is called again. Can not be called concurrently.
 func (s *StoreFile) ReadFrom(r io.Reader) (n int64, err error) {
 	if s.tmpfile != nil {
-		s.Close()
+		// reuse the existing temporary file
+		err = s.tmpfile.Truncate(0)
+		if err == nil {
+			_, err = s.tmpfile.Seek(0, io.SeekStart)
+		}
+		s.size = 0
+		if err != nil {
+			if s.fixed {
+				return 0, err
+			}
+			s.Close()
+		}
 	}
-	s.tmpfile, err = ioutil.TempFile("", "gotmp")
-	if err != nil {
-		return 0, err
+	if s.tmpfile == nil && s.fixed {
+		return 0, os.ErrClosed
+	}
+	if s.tmpfile == nil {
+		s.tmpfile, err = s.fs().CreateTemp(s.dir, s.prefix)
+		if err != nil {
+			return 0, err
+		}
 	}
-	n, err = io.Copy(s.tmpfile, r)
+	var w io.Writer = s.tmpfile
+	if s.syncEvery > 0 {
+		w = &syncWriter{f: s.tmpfile, every: s.syncEvery}
+	}
+	n, err = io.Copy(w, r)
 	s.size = n
 	return n, err
 }
 
+// syncWriter writes to a file and syncs it after every "every" bytes.
+type syncWriter struct {
+	f       File
+	every   int64
+	written int64
+}
+
+func (w *syncWriter) Write(p []byte) (n int, err error) {
+	n, err = w.f.Write(p)
+	w.written += int64(n)
+	if err == nil && w.written >= w.every {
+		w.written = 0
+		err = w.f.Sync()
+	}
+	return n, err
+}
+
 // ReadAt reads len(b) bytes from the Store starting at byte offset off. It
 // returns the number of bytes read and the error, if any. ReadAt always
 // returns a non-nil error when n < len(b). At end of file, that error is
@@ -81,7 +225,10 @@ func (s *StoreFile) Close() error {
 	}
 	name := s.tmpfile.Name()
 	err := s.tmpfile.Close()
-	err2 := os.Remove(name)
+	var err2 error
+	if !s.keep {
+		err2 = s.fs().Remove(name)
+	}
 	s.tmpfile = nil
 	s.size = 0
 
@@ -94,7 +241,8 @@ func (s *StoreFile) Close() error {
 // StoreMemory takes data from io.Reader and provides io.ReaderAt backed by
 // a memory buffer. It implements the Store interface.
 type StoreMemory struct {
-	rdr *bytes.Reader
+	rdr   *bytes.Reader // created once by ReadFrom, shared by ReadAt calls
+	limit int64
 }
 
 var _ Store = (*StoreMemory)(nil)
@@ -103,11 +251,27 @@ func NewStoreMemory() *StoreMemory {
 	return &StoreMemory{}
 }
 
+// NewStoreMemoryLimit creates a StoreMemory which stores at most maxBytes
+// bytes. If more data is available, ReadFrom stores the first maxBytes
+// bytes and returns ErrStoreLimit.
+func NewStoreMemoryLimit(maxBytes int64) *StoreMemory {
+	return &StoreMemory{limit: maxBytes}
+}
+
 // Read and store the contents of r to a memory buffer. Previous contents
-// (if any) are erased.
+// (if any) are erased. If the StoreMemory has a size limit and it is
+// exceeded, ErrStoreLimit is returned.
 func (s *StoreMemory) ReadFrom(r io.Reader) (n int64, err error) {
 	var buf bytes.Buffer
+	if s.limit > 0 {
+		r = io.LimitReader(r, s.limit+1)
+	}
 	n, err = buf.ReadFrom(r)
+	if err == nil && s.limit > 0 && n > s.limit {
+		buf.Truncate(int(s.limit))
+		n = s.limit
+		err = ErrStoreLimit
+	}
 	s.rdr = bytes.NewReader(buf.Bytes())
 
 	return n, err
@@ -142,6 +306,7 @@ func (s *StoreMemory) Close() error {
 // size limit is exceeded, a secondary store is used. If secondary store
 // is nil, error is returned if the size limit is exceeded.
 type LimitedStore struct {
+	mu        sync.RWMutex // guards s, held by ReadFrom for its duration
 	s         Store
 	primary   Store
 	limit     int64
@@ -167,6 +332,9 @@ func NewLimitedStore(primary Store, limit int64, secondary Store) *LimitedStore
 // reached, fall back to the secondary store or return ErrStoreLimit
 // if secondary store is nil.
 func (s *LimitedStore) ReadFrom(r io.Reader) (n int64, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.s != nil {
 		s.s.Close()
 	}
@@ -183,8 +351,13 @@ func (s *LimitedStore) ReadFrom(r io.Reader) (n int64, err error) {
 		return n, ErrStoreLimit
 	}
 
-	// move already received data from primary store to secondary store
-	srdr := io.NewSectionReader(s.primary, 0, n)
+	// move already received data from primary store to secondary store,
+	// the primary store is closed (releasing its memory) as soon as its
+	// contents have been moved, not after the whole stream is copied
+	srdr := &closeOnEOFReader{
+		r: io.NewSectionReader(s.primary, 0, n),
+		c: s.primary,
+	}
 	n, err = s.secondary.ReadFrom(io.MultiReader(srdr, r))
 	s.primary.Close()
 	s.s = s.secondary
@@ -192,7 +365,32 @@ func (s *LimitedStore) ReadFrom(r io.Reader) (n int64, err error) {
 	return n, err
 }
 
+// closeOnEOFReader closes c when r reaches EOF.
+type closeOnEOFReader struct {
+	r io.Reader
+	c io.Closer
+}
+
+func (r *closeOnEOFReader) Read(p []byte) (n int, err error) {
+	if r.r == nil {
+		return 0, io.EOF
+	}
+	n, err = r.r.Read(p)
+	if err == io.EOF {
+		r.c.Close()
+		r.r = nil
+	}
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the Store starting at byte offset off.
+// If ReadFrom is in progress, ReadAt waits for it to complete, so that it
+// never observes the Store in the middle of moving data from the primary
+// store to the secondary store. It is safe for concurrent use.
 func (s *LimitedStore) ReadAt(p []byte, off int64) (n int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	if s.s == nil {
 		return 0, nil
 	}
@@ -200,6 +398,9 @@ func (s *LimitedStore) ReadAt(p []byte, off int64) (n int, err error) {
 }
 
 func (s *LimitedStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.s == nil {
 		return nil
 	}
@@ -207,3 +408,195 @@ func (s *LimitedStore) Close() error {
 	s.s = nil
 	return err
 }
+
+// Tier is a single tier of TieredStore: a Store holding up to Limit bytes.
+type Tier struct {
+	Store Store
+	Limit int64
+}
+
+// TieredStore stores data to an ordered list of tiers, for example memory
+// followed by a temporary file. The first Limit bytes are stored to the
+// first tier, the following bytes to the second tier and so on. Unlike
+// with nested LimitedStores, data already written to a tier is never moved
+// to another tier. ErrStoreLimit is returned if the data does not fit in
+// the combined limits of all tiers.
+type TieredStore struct {
+	tiers []Tier
+	sizes []int64
+	size  int64
+}
+
+var _ Store = (*TieredStore)(nil)
+
+// NewTieredStore creates a new TieredStore using the given tiers in order.
+func NewTieredStore(tiers ...Tier) *TieredStore {
+	return &TieredStore{
+		tiers: tiers,
+		sizes: make([]int64, len(tiers)),
+	}
+}
+
+// Store the contents of r to the tiers. Previous contents (if any) are
+// erased. Can not be called concurrently.
+func (s *TieredStore) ReadFrom(r io.Reader) (n int64, err error) {
+	s.Close()
+
+	for i, t := range s.tiers {
+		m, err := t.Store.ReadFrom(io.LimitReader(r, t.Limit))
+		s.sizes[i] = m
+		s.size += m
+		if err != nil {
+			return s.size, err
+		}
+		if m < t.Limit {
+			return s.size, nil
+		}
+	}
+	// all tiers are full, check if there is more data
+	var b [1]byte
+	m, err := io.ReadFull(r, b[:])
+	if m > 0 {
+		return s.size, ErrStoreLimit
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return s.size, err
+}
+
+// ReadAt reads len(b) bytes from the Store starting at byte offset off. It
+// returns the number of bytes read and the error, if any. ReadAt always
+// returns a non-nil error when n < len(b). At end of file, that error is
+// io.EOF. It is safe for concurrent use.
+func (s *TieredStore) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
+	tierOff := int64(0)
+	for i, t := range s.tiers {
+		if n == len(p) {
+			break
+		}
+		size := s.sizes[i]
+		pos := off + int64(n) - tierOff
+		tierOff += size
+		if pos >= size {
+			continue
+		}
+		buf := p[n:]
+		if int64(len(buf)) > size-pos {
+			buf = buf[:size-pos]
+		}
+		m, err := t.Store.ReadAt(buf, pos)
+		n += m
+		if err != nil && !(err == io.EOF && m == len(buf)) {
+			return n, err
+		}
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Size returns the amount of data (in bytes) in the Store.
+func (s *TieredStore) Size() int64 {
+	return s.size
+}
+
+// Close closes all tiers.
+func (s *TieredStore) Close() error {
+	var err error
+	for i, t := range s.tiers {
+		if err2 := t.Store.Close(); err2 != nil && err == nil {
+			err = err2
+		}
+		s.sizes[i] = 0
+	}
+	s.size = 0
+	return err
+}
+
+// StoreWriteThrough stores data to a caller provided io.WriterAt and reads
+// it back from a caller provided io.ReaderAt, which is typically a view of
+// the same storage, for example an *os.File. This allows the fallback
+// download of HTTPReaderAt to also populate a persistent copy of the
+// remote file. It implements the Store interface.
+type StoreWriteThrough struct {
+	w    io.WriterAt
+	r    io.ReaderAt
+	size int64
+}
+
+var _ Store = (*StoreWriteThrough)(nil)
+
+// NewStoreWriteThrough creates a StoreWriteThrough writing to w and reading
+// from r. If r is nil, w must also implement io.ReaderAt and it is used
+// for reading. Closing the StoreWriteThrough does not close w or r, the
+// caller remains responsible for them.
+func NewStoreWriteThrough(w io.WriterAt, r io.ReaderAt) (*StoreWriteThrough, error) {
+	if r == nil {
+		var ok bool
+		if r, ok = w.(io.ReaderAt); !ok {
+			return nil, errors.New("writer does not implement io.ReaderAt")
+		}
+	}
+	return &StoreWriteThrough{w: w, r: r}, nil
+}
+
+// Read and store the contents of r to the WriterAt starting at offset
+// zero. Previous contents are overwritten, but the WriterAt is not
+// truncated; ReadAt only returns the data stored by the latest ReadFrom.
+func (s *StoreWriteThrough) ReadFrom(r io.Reader) (n int64, err error) {
+	s.size = 0
+	buf := make([]byte, 32*1024)
+	for {
+		m, rerr := r.Read(buf)
+		if m > 0 {
+			_, err = s.w.WriteAt(buf[:m], n)
+			if err != nil {
+				break
+			}
+			n += int64(m)
+		}
+		if rerr != nil {
+			if rerr != io.EOF {
+				err = rerr
+			}
+			break
+		}
+	}
+	s.size = n
+	return n, err
+}
+
+// ReadAt reads len(b) bytes from the Store starting at byte offset off. It
+// returns the number of bytes read and the error, if any. ReadAt always
+// returns a non-nil error when n < len(b). At end of file, that error is
+// io.EOF. It is safe for concurrent use if the underlying ReaderAt is.
+func (s *StoreWriteThrough) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+	if rem := s.size - off; int64(len(p)) > rem {
+		n, err = s.r.ReadAt(p[:rem], off)
+		if err == nil {
+			err = io.EOF
+		}
+		return n, err
+	}
+	return s.r.ReadAt(p, off)
+}
+
+// Size returns the amount of data (in bytes) in the Store.
+func (s *StoreWriteThrough) Size() int64 {
+	return s.size
+}
+
+// Close forgets the stored data. The WriterAt and the ReaderAt are not
+// closed and the data written to them is retained.
+func (s *StoreWriteThrough) Close() error {
+	s.size = 0
+	return nil
+}
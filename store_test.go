@@ -0,0 +1,427 @@
+package httpreaderat
+
+import (
+	"bytes"
+	"compress/gzip"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// noRangeHandler serves data without Range Request support.
+func noRangeHandler(data []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		w.Write(data)
+	}
+}
+
+func TestLimitedStoreConcurrent(t *testing.T) {
+	data := makeTestData(10000)
+	ts := newTestServer(t, data, noRangeHandler(data))
+	ls := NewLimitedStore(NewStoreMemory(), 1000, NewStoreMemory())
+	ra := newTestReaderAt(t, ts.URL, ls)
+
+	var wg sync.WaitGroup
+	// refill the Store concurrently with the reads, which must always
+	// observe the complete contents
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := ls.ReadFrom(bytes.NewReader(data)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			p := make([]byte, 700)
+			for i := 0; i < 50; i++ {
+				off := int64((g*1300 + i*170) % (len(data) - len(p)))
+				n, err := ra.ReadAt(p, off)
+				if err != nil || n != len(p) {
+					t.Errorf("ReadAt(%d) = %d, %v", off, n, err)
+					return
+				}
+				if !bytes.Equal(p, data[off:off+int64(n)]) {
+					t.Errorf("ReadAt(%d) returned wrong data", off)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if ts.Requests() != 1 {
+		t.Errorf("requests = %d; want 1", ts.Requests())
+	}
+}
+
+func TestStoreMemoryReadAtAllocs(t *testing.T) {
+	data := makeTestData(10000)
+	s := NewStoreMemory()
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 100)
+	allocs := testing.AllocsPerRun(100, func() {
+		s.ReadAt(p, 1234)
+	})
+	if allocs != 0 {
+		t.Errorf("StoreMemory.ReadAt allocates %v times per call; want 0", allocs)
+	}
+}
+
+func BenchmarkStoreMemoryReadAt(b *testing.B) {
+	data := makeTestData(1024 * 1024)
+	s := NewStoreMemory()
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.SetBytes(4096)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		p := make([]byte, 4096)
+		off := int64(0)
+		for pb.Next() {
+			if _, err := s.ReadAt(p, off); err != nil {
+				b.Error(err)
+				return
+			}
+			off = (off + int64(len(p))) % int64(len(data))
+		}
+	})
+}
+
+func TestStoreMemoryLimit(t *testing.T) {
+	data := makeTestData(1000)
+	tests := []struct {
+		size    int
+		wantN   int64
+		wantErr error
+	}{
+		{999, 999, nil},
+		{1000, 1000, nil},
+		{1001, 1000, ErrStoreLimit},
+		{5000, 1000, ErrStoreLimit},
+	}
+	for _, tt := range tests {
+		s := NewStoreMemoryLimit(1000)
+		src := makeTestData(tt.size)
+		n, err := s.ReadFrom(bytes.NewReader(src))
+		if n != tt.wantN || err != tt.wantErr {
+			t.Errorf("size %d: ReadFrom = %d, %v; want %d, %v", tt.size, n, err, tt.wantN, tt.wantErr)
+			continue
+		}
+		if s.Size() != tt.wantN {
+			t.Errorf("size %d: Size = %d; want %d", tt.size, s.Size(), tt.wantN)
+		}
+		p := make([]byte, tt.wantN)
+		if _, err := s.ReadAt(p, 0); err != nil || !bytes.Equal(p, data[:tt.wantN]) {
+			t.Errorf("size %d: ReadAt returned wrong data, %v", tt.size, err)
+		}
+	}
+}
+
+func TestStoreMemoryLimitInLimitedStore(t *testing.T) {
+	data := makeTestData(1000)
+	secondary := NewStoreMemory()
+	s := NewLimitedStore(NewStoreMemoryLimit(100), 500, secondary)
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != ErrStoreLimit {
+		t.Errorf("ReadFrom error = %v; want %v", err, ErrStoreLimit)
+	}
+}
+
+func TestStoreMemoryLimitFallback(t *testing.T) {
+	data := makeTestData(1000)
+	ts := newTestServer(t, data, noRangeHandler(data))
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	ra, err := New(nil, req, NewStoreMemoryLimit(100))
+	if err == nil {
+		ra.Close()
+		t.Fatal("New succeeded; want error")
+	}
+	if !stderrors.Is(err, ErrStoreLimit) {
+		t.Errorf("New error = %v; want %v", err, ErrStoreLimit)
+	}
+}
+
+func TestStoreFileReuse(t *testing.T) {
+	s := NewStoreFileWithOptions(t.TempDir(), "test", 0)
+	defer s.Close()
+
+	data := makeTestData(1000)
+	if _, err := s.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	f := s.tmpfile
+	name := f.Name()
+
+	// shorter contents replace the previous ones in the same file
+	if _, err := s.ReadFrom(bytes.NewReader(data[:100])); err != nil {
+		t.Fatal(err)
+	}
+	if s.tmpfile != f || s.tmpfile.Name() != name {
+		t.Errorf("temporary file changed from %s to %s", name, s.tmpfile.Name())
+	}
+	if s.Size() != 100 {
+		t.Errorf("Size = %d; want 100", s.Size())
+	}
+	p := make([]byte, 200)
+	n, err := s.ReadAt(p, 0)
+	if n != 100 || err != io.EOF || !bytes.Equal(p[:n], data[:100]) {
+		t.Errorf("ReadAt = %d, %v; want 100, EOF", n, err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != 100 {
+		t.Errorf("file size = %d; want 100", fi.Size())
+	}
+
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("temporary file not removed by Close: %v", err)
+	}
+}
+
+// observingReader reads from r and calls observe when reading past the
+// first n bytes.
+type observingReader struct {
+	r       io.Reader
+	n       int64
+	pos     int64
+	observe func()
+}
+
+func (r *observingReader) Read(p []byte) (int, error) {
+	if r.pos >= r.n && r.observe != nil {
+		r.observe()
+		r.observe = nil
+	}
+	if rem := r.n - r.pos; rem > 0 && int64(len(p)) > rem {
+		p = p[:rem]
+	}
+	n, err := r.r.Read(p)
+	r.pos += int64(n)
+	return n, err
+}
+
+func TestLimitedStoreReleasesPrimary(t *testing.T) {
+	data := makeTestData(10000)
+	primary := NewStoreMemory()
+	s := NewLimitedStore(primary, 1000, NewStoreMemory())
+	released := false
+	src := &observingReader{r: bytes.NewReader(data), n: 1000, observe: func() {
+		// the data beyond the limit is being copied to the secondary
+		// store, the primary store must have been released by now
+		released = primary.rdr == nil
+	}}
+	n, err := s.ReadFrom(src)
+	if n != int64(len(data)) || err != nil {
+		t.Fatalf("ReadFrom = %d, %v", n, err)
+	}
+	if !released {
+		t.Error("primary store not released before copying the rest of the data")
+	}
+	p := make([]byte, len(data))
+	if _, err = s.ReadAt(p, 0); err != nil || !bytes.Equal(p, data) {
+		t.Errorf("ReadAt returned wrong data, %v", err)
+	}
+}
+
+func TestStoreFileFromFile(t *testing.T) {
+	data := makeTestData(1000)
+	for _, deleteOnClose := range []bool{false, true} {
+		f, err := ioutil.TempFile(t.TempDir(), "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := f.Name()
+		s := NewStoreFileFromFile(f, deleteOnClose)
+		for _, src := range [][]byte{data, data[:100]} {
+			if _, err = s.ReadFrom(bytes.NewReader(src)); err != nil {
+				t.Fatal(err)
+			}
+			p := make([]byte, len(src))
+			if _, err = s.ReadAt(p, 0); err != nil || !bytes.Equal(p, src) {
+				t.Errorf("ReadAt returned wrong data, %v", err)
+			}
+			if s.tmpfile != f {
+				t.Error("the given file is not used")
+			}
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Stat(name)
+		if deleteOnClose && !os.IsNotExist(err) {
+			t.Errorf("file not removed by Close: %v", err)
+		}
+		if !deleteOnClose && err != nil {
+			t.Errorf("file removed by Close: %v", err)
+		}
+		if _, err = s.ReadFrom(bytes.NewReader(data)); err != os.ErrClosed {
+			t.Errorf("ReadFrom after Close error = %v; want %v", err, os.ErrClosed)
+		}
+	}
+}
+
+// gzipNoRangeHandler serves data without Range Request support,
+// compressed with gzip if the request accepts it.
+func gzipNoRangeHandler(data []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", testModTime.Format(http.TimeFormat))
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write(data)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(data)
+		zw.Close()
+	}
+}
+
+func TestFallbackProbeEncoding(t *testing.T) {
+	data := bytes.Repeat([]byte("compressible"), 1000)
+	ts := newTestServer(t, data, gzipNoRangeHandler(data))
+	ra := newTestReaderAt(t, ts.URL, NewStoreMemory(), WithAcceptEncoding("gzip", "identity"))
+	if ra.Size() != int64(len(data)) {
+		t.Errorf("Size = %d; want %d", ra.Size(), len(data))
+	}
+	p := make([]byte, len(data))
+	if n, err := ra.ReadAt(p, 0); n != len(p) || err != nil || !bytes.Equal(p, data) {
+		t.Errorf("ReadAt = %d, %v; want the uncompressed file", n, err)
+	}
+	// the compressed response is replaced by an unencoded one
+	if ts.Requests() != 2 {
+		t.Errorf("requests = %d; want 2", ts.Requests())
+	}
+}
+
+func TestNewWithStoreFactory(t *testing.T) {
+	data := makeTestData(1000)
+	for _, ranges := range []bool{true, false} {
+		handler := http.HandlerFunc(nil)
+		if !ranges {
+			handler = noRangeHandler(data)
+		}
+		ts := newTestServer(t, data, handler)
+		var created []*StoreFile
+		factory := func() Store {
+			s := NewStoreFileWithOptions(t.TempDir(), "test", 0)
+			created = append(created, s)
+			return s
+		}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		ra, err := NewWithStoreFactory(nil, req, factory)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := make([]byte, 100)
+		if n, err := ra.ReadAt(p, 100); n != len(p) || err != nil || !bytes.Equal(p, data[100:200]) {
+			t.Errorf("ranges %v: ReadAt = %d, %v", ranges, n, err)
+		}
+		// the Store is only created for the fallback
+		wantCreated := 0
+		if !ranges {
+			wantCreated = 1
+		}
+		if len(created) != wantCreated {
+			t.Fatalf("ranges %v: %d Stores created; want %d", ranges, len(created), wantCreated)
+		}
+		if err = ra.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !ranges && created[0].tmpfile != nil {
+			t.Error("the created Store is not closed by Close")
+		}
+	}
+}
+
+func TestCancelBuffering(t *testing.T) {
+	data := makeTestData(1000)
+	sent := make(chan struct{})
+	ts := newTestServer(t, data, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Write(data[:100])
+		w.(http.Flusher).Flush()
+		close(sent)
+		// the rest of the file never arrives
+		<-r.Context().Done()
+	})
+	cancel := make(chan struct{})
+	go func() {
+		<-sent
+		close(cancel)
+	}()
+	bs := NewStoreFileWithOptions(t.TempDir(), "test", 0)
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	ra, err := New(nil, req, bs, WithCancelBuffering(cancel))
+	if err == nil {
+		ra.Close()
+		t.Fatal("New succeeded; want error")
+	}
+	if !stderrors.Is(err, ErrBufferingCanceled) {
+		t.Errorf("New error = %v; want %v", err, ErrBufferingCanceled)
+	}
+	if bs.tmpfile != nil {
+		t.Error("the partially filled Store is not closed")
+	}
+}
+
+func TestTieredStore(t *testing.T) {
+	data := makeTestData(1000)
+	mem1, mem2 := NewStoreMemory(), NewStoreMemory()
+	file := NewStoreFileWithOptions(t.TempDir(), "test", 0)
+	s := NewTieredStore(Tier{mem1, 100}, Tier{mem2, 300}, Tier{file, 1000})
+	defer s.Close()
+
+	for _, src := range [][]byte{data, data[:250]} {
+		n, err := s.ReadFrom(bytes.NewReader(src))
+		if n != int64(len(src)) || err != nil {
+			t.Fatalf("ReadFrom = %d, %v", n, err)
+		}
+		if s.Size() != int64(len(src)) {
+			t.Errorf("Size = %d; want %d", s.Size(), len(src))
+		}
+		// each byte is written to a single tier only
+		if sum := mem1.Size() + mem2.Size() + file.Size(); sum != int64(len(src)) {
+			t.Errorf("tiers hold %d bytes; want %d", sum, len(src))
+		}
+		if mem1.Size() != 100 {
+			t.Errorf("first tier holds %d bytes; want 100", mem1.Size())
+		}
+		// spanning the tiers
+		p := make([]byte, len(src))
+		if n, err := s.ReadAt(p, 0); n != len(p) || err != nil || !bytes.Equal(p, src) {
+			t.Errorf("ReadAt = %d, %v", n, err)
+		}
+		p = make([]byte, 100)
+		n2, err := s.ReadAt(p, int64(len(src))-50)
+		if n2 != 50 || err != io.EOF || !bytes.Equal(p[:n2], src[len(src)-50:]) {
+			t.Errorf("ReadAt near the end = %d, %v; want 50, EOF", n2, err)
+		}
+	}
+
+	if n, err := s.ReadFrom(bytes.NewReader(makeTestData(1400))); n != 1400 || err != nil {
+		t.Errorf("ReadFrom filling all the tiers = %d, %v", n, err)
+	}
+	if _, err := s.ReadFrom(bytes.NewReader(makeTestData(1401))); err != ErrStoreLimit {
+		t.Errorf("ReadFrom beyond the limits error = %v; want %v", err, ErrStoreLimit)
+	}
+}
@@ -0,0 +1,71 @@
+package httpreaderat
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// TarGzIndex is an index of a .tar.gz archive which allows random access
+// to its members. It requires that the archive was compressed so that
+// each member begins a new gzip member (gzip members can be concatenated).
+// The JSON encoding of the index is:
+//
+//	{
+//	  "members": {
+//	    "dir/file.txt": {"offset": 0, "length": 1234, "size": 5678},
+//	    ...
+//	  }
+//	}
+type TarGzIndex struct {
+	Members map[string]TarGzIndexEntry `json:"members"`
+}
+
+// TarGzIndexEntry describes the location of a single member of a .tar.gz
+// archive. The compressed bytes [Offset, Offset+Length) of the archive
+// decompress to the tar header of the member followed by its contents.
+type TarGzIndexEntry struct {
+	Offset int64 `json:"offset"` // offset of the compressed data
+	Length int64 `json:"length"` // length of the compressed data
+	Size   int64 `json:"size"`   // uncompressed size of the contents
+}
+
+// ErrMemberNotFound error is returned if the requested archive member
+// does not exist.
+var ErrMemberNotFound = errors.New("archive member not found")
+
+// LoadTarGzIndex reads a JSON encoded TarGzIndex from r.
+func LoadTarGzIndex(r io.Reader) (*TarGzIndex, error) {
+	var idx TarGzIndex
+	err := json.NewDecoder(r).Decode(&idx)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid tar.gz index")
+	}
+	return &idx, nil
+}
+
+// NewTarGzMember returns an io.Reader which reads the contents of the
+// member called name from a .tar.gz archive accessed through ra (which is
+// typically a HTTPReaderAt). Only the compressed bytes of that member are
+// retrieved and decompressed.
+func NewTarGzMember(ra io.ReaderAt, index *TarGzIndex, name string) (io.Reader, error) {
+	e, ok := index.Members[name]
+	if !ok {
+		return nil, ErrMemberNotFound
+	}
+	zr, err := gzip.NewReader(io.NewSectionReader(ra, e.Offset, e.Length))
+	if err != nil {
+		return nil, err
+	}
+	tr := tar.NewReader(zr)
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid tar.gz member")
+	}
+	if hdr.Name != name || hdr.Size != e.Size {
+		return nil, errors.New("tar.gz index does not match archive")
+	}
+	return tr, nil
+}
@@ -0,0 +1,42 @@
+package httpreaderat
+
+import (
+	"context"
+)
+
+// Tracer is the interface to a distributed tracing system such as
+// OpenTelemetry. It can be set with WithTracer. StartSpan starts a new span
+// called name as a child of the span in ctx (if any) and returns the
+// context carrying the new span along with a function which ends it.
+type Tracer interface {
+	StartSpan(ctx context.Context, name string) (context.Context, EndSpanFunc)
+}
+
+// EndSpanFunc ends a span started by Tracer. The info contains the
+// attributes of the span and err is the error of the traced operation,
+// if any.
+type EndSpanFunc func(info SpanInfo, err error)
+
+// SpanInfo contains the attributes of a traced operation.
+type SpanInfo struct {
+	Off        int64 // requested byte offset
+	Len        int64 // requested length in bytes
+	StatusCode int   // HTTP status code, zero if no request was made
+	Bytes      int   // number of bytes read
+}
+
+// Names of the spans started by HTTPReaderAt.
+const (
+	SpanReadAt  = "httpreaderat.ReadAt"
+	SpanRequest = "httpreaderat.request"
+	SpanAttempt = "httpreaderat.attempt" // each attempt of a request
+)
+
+// startSpan starts a span if a Tracer is set. The returned function must
+// be called to end the span.
+func (ra *HTTPReaderAt) startSpan(ctx context.Context, name string) (context.Context, EndSpanFunc) {
+	if ra.tracer == nil {
+		return ctx, func(SpanInfo, error) {}
+	}
+	return ra.tracer.StartSpan(ctx, name)
+}
@@ -0,0 +1,357 @@
+package httpreaderat
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+// zipTailSize is the maximum size of the zip end of central directory
+// record including the maximum length archive comment.
+const zipTailSize = 64*1024 + 22
+
+// NewForZip creates a new HTTPReaderAt like New, but optimized for use
+// with "archive/zip". The end of the file, where the zip end of central
+// directory record is located, is prefetched with a single request into
+// a block cache. The central directory, which zip.NewReader reads in
+// small chunks, is then prefetched with another request if it fits in
+// the block cache, so that opening the archive takes about three requests
+// regardless of the number of members. The block cache is enabled with
+// default settings unless WithBlockCache is given in the options.
+func NewForZip(client *http.Client, req *http.Request, bs Store, opts ...Option) (ra *HTTPReaderAt, err error) {
+	opts = append([]Option{WithBlockCache(64*1024, 64)}, opts...)
+	ra, err = New(client, req, bs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if ra.usebs || ra.meta.Size <= 0 {
+		return ra, nil
+	}
+	first := ra.meta.Size - zipTailSize
+	if first < 0 {
+		first = 0
+	}
+	_, err = ra.fetchBlocks(context.Background(), first/ra.cache.blockSize,
+		(ra.meta.Size-1)/ra.cache.blockSize)
+	if err != nil {
+		ra.Close()
+		return nil, err
+	}
+	d, err := readZipDir(ra, ra.meta.Size)
+	if err != nil {
+		// not a zip archive, leave it to the caller to find out
+		return ra, nil
+	}
+	// blocks needed for the central directory and the tail
+	blocks := (int64(d.size)+zipTailSize)/ra.cache.blockSize + 2
+	if d.size > 0 && (ra.cache.maxBlocks <= 0 || blocks <= int64(ra.cache.maxBlocks)) {
+		err = ra.Prefetch([]Range{{Off: int64(d.offset), Len: int64(d.size)}})
+		if err != nil {
+			ra.Close()
+			return nil, err
+		}
+	}
+	return ra, nil
+}
+
+// ErrNotZip error is returned if the zip end of central directory record
+// can not be found.
+var ErrNotZip = errors.New("not a valid zip file")
+
+const (
+	zipEOCDSig         = 0x06054b50
+	zipEOCDLen         = 22
+	zip64LocatorSig    = 0x07064b50
+	zip64LocatorLen    = 20
+	zip64EOCDSig       = 0x06064b50
+	zip64EOCDLen       = 56
+	zipCentralDirSig   = 0x02014b50
+	zipCentralDirLen   = 46
+	zip64ExtraID       = 0x0001
+	zipUint16Max       = 0xffff
+	zipUint32Max       = 0xffffffff
+	zipMaxCentralDirSz = 1 << 30 // sanity limit
+)
+
+// ZipEntry describes a member of a zip archive as listed in the central
+// directory.
+type ZipEntry struct {
+	Name             string
+	Method           uint16 // compression method (0 = stored, 8 = deflated)
+	CompressedSize   uint64
+	UncompressedSize uint64
+	HeaderOffset     int64 // offset of the local file header
+}
+
+// zipDir describes the location of the central directory.
+type zipDir struct {
+	entries uint64
+	size    uint64
+	offset  uint64
+}
+
+// readZipDir locates the central directory of a zip archive of size
+// bytes accessed through r.
+func readZipDir(r io.ReaderAt, size int64) (d zipDir, err error) {
+	_, data, err := readEOCD(r, size)
+	if err != nil {
+		return d, err
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case zip64EOCDSig:
+		if len(data) < zip64EOCDLen {
+			return d, ErrNotZip
+		}
+		d.entries = binary.LittleEndian.Uint64(data[32:])
+		d.size = binary.LittleEndian.Uint64(data[40:])
+		d.offset = binary.LittleEndian.Uint64(data[48:])
+	case zipEOCDSig:
+		d.entries = uint64(binary.LittleEndian.Uint16(data[10:]))
+		d.size = uint64(binary.LittleEndian.Uint32(data[12:]))
+		d.offset = uint64(binary.LittleEndian.Uint32(data[16:]))
+	default:
+		return d, ErrNotZip
+	}
+	if d.offset+d.size > uint64(size) {
+		return d, ErrNotZip
+	}
+	return d, nil
+}
+
+// findZipEOCD returns the position of the end of central directory record
+// in tail or -1 if it is not found.
+func findZipEOCD(tail []byte) int {
+	for i := len(tail) - zipEOCDLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != zipEOCDSig {
+			continue
+		}
+		commentLen := int(binary.LittleEndian.Uint16(tail[i+20:]))
+		if i+zipEOCDLen+commentLen <= len(tail) {
+			return i
+		}
+	}
+	return -1
+}
+
+// ReadEOCD retrieves the end of the zip archive accessed through ra with
+// a single request and locates the end of central directory record in
+// it. It returns the offset of the record and the data from that offset
+// to the end of the file. If the archive has a zip64 end of central
+// directory locator, the offset and the data of the zip64 end of central
+// directory record are returned instead; they include the locator and the
+// end of central directory record. ErrNotZip is returned if the record is
+// not found.
+func ReadEOCD(ra *HTTPReaderAt) (eocdOffset int64, data []byte, err error) {
+	return readEOCD(ra, ra.Size())
+}
+
+// readEOCD is ReadEOCD for a zip archive of size bytes accessed through r.
+func readEOCD(r io.ReaderAt, size int64) (eocdOffset int64, data []byte, err error) {
+	if size < zipEOCDLen {
+		return 0, nil, ErrNotZip
+	}
+	tailLen := int64(zipTailSize)
+	if tailLen > size {
+		tailLen = size
+	}
+	tailOff := size - tailLen
+	tail := make([]byte, tailLen)
+	if _, err = r.ReadAt(tail, tailOff); err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	i := findZipEOCD(tail)
+	if i == -1 {
+		return 0, nil, ErrNotZip
+	}
+	eocdOffset, data = tailOff+int64(i), tail[i:]
+
+	if i < zip64LocatorLen {
+		return eocdOffset, data, nil
+	}
+	loc := tail[i-zip64LocatorLen : i]
+	if binary.LittleEndian.Uint32(loc) != zip64LocatorSig {
+		return eocdOffset, data, nil
+	}
+	recOff := int64(binary.LittleEndian.Uint64(loc[8:]))
+	if recOff < 0 || recOff+zip64EOCDLen > eocdOffset-zip64LocatorLen {
+		return 0, nil, ErrNotZip
+	}
+	if recOff >= tailOff {
+		return recOff, tail[recOff-tailOff:], nil
+	}
+	// the zip64 record is not within the tail, fetch the missing part
+	head := make([]byte, tailOff-recOff)
+	if _, err = r.ReadAt(head, recOff); err != nil {
+		return 0, nil, err
+	}
+	return recOff, append(head, tail...), nil
+}
+
+// IsZip64 reports whether the zip archive accessed through ra has a zip64
+// end of central directory record, as archives larger than 4 GB or with
+// more than 65535 members do. The zip64 end of central directory locator
+// is looked for in the end of the archive retrieved as with ReadEOCD.
+// ErrNotZip is returned if the end of central directory record or the
+// zip64 record pointed to by the locator is not found.
+func IsZip64(ra *HTTPReaderAt) (bool, error) {
+	_, data, err := ReadEOCD(ra)
+	if err != nil {
+		return false, err
+	}
+	switch binary.LittleEndian.Uint32(data) {
+	case zip64EOCDSig:
+		return true, nil
+	case zipEOCDSig:
+		return false, nil
+	}
+	return false, ErrNotZip
+}
+
+// ListZipEntries returns the members of the zip archive accessed through
+// ra. Only the end of central directory record and the central directory
+// are retrieved. This is cheaper than zip.NewReader if only a listing is
+// needed.
+func ListZipEntries(ra *HTTPReaderAt) ([]ZipEntry, error) {
+	d, err := readZipDir(ra, ra.Size())
+	if err != nil {
+		return nil, err
+	}
+	if d.size > zipMaxCentralDirSz {
+		return nil, errors.New("zip central directory too large")
+	}
+	dir := make([]byte, d.size)
+	if _, err = ra.ReadAt(dir, int64(d.offset)); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parseZipCentralDir(dir)
+}
+
+func parseZipCentralDir(dir []byte) (entries []ZipEntry, err error) {
+	for len(dir) >= zipCentralDirLen {
+		if binary.LittleEndian.Uint32(dir) != zipCentralDirSig {
+			break
+		}
+		nameLen := int(binary.LittleEndian.Uint16(dir[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(dir[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(dir[32:]))
+		recLen := zipCentralDirLen + nameLen + extraLen + commentLen
+		if recLen > len(dir) {
+			return nil, ErrNotZip
+		}
+		e := ZipEntry{
+			Name:             string(dir[zipCentralDirLen : zipCentralDirLen+nameLen]),
+			Method:           binary.LittleEndian.Uint16(dir[10:]),
+			CompressedSize:   uint64(binary.LittleEndian.Uint32(dir[20:])),
+			UncompressedSize: uint64(binary.LittleEndian.Uint32(dir[24:])),
+			HeaderOffset:     int64(binary.LittleEndian.Uint32(dir[42:])),
+		}
+		extra := dir[zipCentralDirLen+nameLen : zipCentralDirLen+nameLen+extraLen]
+		applyZip64Extra(&e, extra)
+		entries = append(entries, e)
+		dir = dir[recLen:]
+	}
+	return entries, nil
+}
+
+// applyZip64Extra replaces the sizes and offset which are set to their
+// maximum value with the values from the zip64 extended information
+// extra field.
+func applyZip64Extra(e *ZipEntry, extra []byte) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		l := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+l > len(extra) {
+			return
+		}
+		if id != zip64ExtraID {
+			extra = extra[4+l:]
+			continue
+		}
+		f := bytes.NewReader(extra[4 : 4+l])
+		var v uint64
+		if e.UncompressedSize == zipUint32Max && binary.Read(f, binary.LittleEndian, &v) == nil {
+			e.UncompressedSize = v
+		}
+		if e.CompressedSize == zipUint32Max && binary.Read(f, binary.LittleEndian, &v) == nil {
+			e.CompressedSize = v
+		}
+		if e.HeaderOffset == zipUint32Max && binary.Read(f, binary.LittleEndian, &v) == nil {
+			e.HeaderOffset = int64(v)
+		}
+		return
+	}
+}
+
+// ErrZipEntryCompressed error is returned by StoredZipEntry if the zip
+// entry is compressed.
+var ErrZipEntryCompressed = errors.New("zip entry is compressed")
+
+// StoredZipEntry returns an io.SectionReader over the contents of the zip
+// entry f which must be stored without compression (zip.Store). The data
+// is read directly from ra (typically the HTTPReaderAt given to
+// zip.NewReader) bypassing the decompressor and the checksum verification
+// done by f.Open. ErrZipEntryCompressed is returned for compressed entries.
+func StoredZipEntry(ra io.ReaderAt, f *zip.File) (*io.SectionReader, error) {
+	if f.Method != zip.Store {
+		return nil, ErrZipEntryCompressed
+	}
+	off, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+	return io.NewSectionReader(ra, off, int64(f.CompressedSize64)), nil
+}
+
+// OpenRemoteZipFile opens the member called name of the remote zip archive
+// at url for reading. The archive is accessed with NewForZip; if the
+// server does not support HTTP Range Requests, it is buffered to a Store
+// created with NewDefaultStore. ErrMemberNotFound is returned if the
+// archive does not contain the member. Closing the returned io.ReadCloser
+// releases the Store, if any.
+func OpenRemoteZipFile(client *http.Client, url, name string) (io.ReadCloser, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	ra, err := NewForZip(client, req, nil, WithAutoStore(true))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(ra, ra.Size())
+	if err != nil {
+		ra.Close()
+		return nil, err
+	}
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			ra.Close()
+			return nil, err
+		}
+		return &remoteZipFile{ReadCloser: rc, ra: ra}, nil
+	}
+	ra.Close()
+	return nil, ErrMemberNotFound
+}
+
+// remoteZipFile is a zip archive member which closes the HTTPReaderAt of
+// the archive when closed.
+type remoteZipFile struct {
+	io.ReadCloser
+	ra *HTTPReaderAt
+}
+
+func (f *remoteZipFile) Close() error {
+	err := f.ReadCloser.Close()
+	if err2 := f.ra.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
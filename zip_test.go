@@ -0,0 +1,159 @@
+package httpreaderat
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+// makeTestZip returns a zip archive with n empty stored members. With more
+// than 65535 members archive/zip writes a zip64 end of central directory.
+func makeTestZip(t *testing.T, n int) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for i := 0; i < n; i++ {
+		_, err := zw.CreateHeader(&zip.FileHeader{Name: fmt.Sprint(i), Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+// makeZip64Tail returns an empty zip archive with a zip64 end of central
+// directory record which has extLen bytes of extensible data, so that the
+// record can be placed further away from the end of the archive than
+// zipTailSize.
+func makeZip64Tail(extLen int) []byte {
+	le := binary.LittleEndian
+	rec := make([]byte, zip64EOCDLen+extLen)
+	le.PutUint32(rec, zip64EOCDSig)
+	le.PutUint64(rec[4:], uint64(len(rec)-12))
+	le.PutUint16(rec[12:], 45)
+	le.PutUint16(rec[14:], 45)
+
+	loc := make([]byte, zip64LocatorLen)
+	le.PutUint32(loc, zip64LocatorSig)
+	le.PutUint64(loc[8:], 0) // offset of the zip64 record
+	le.PutUint32(loc[16:], 1)
+
+	eocd := make([]byte, zipEOCDLen)
+	le.PutUint32(eocd, zipEOCDSig)
+	le.PutUint16(eocd[8:], zipUint16Max)
+	le.PutUint16(eocd[10:], zipUint16Max)
+	le.PutUint32(eocd[12:], zipUint32Max)
+	le.PutUint32(eocd[16:], zipUint32Max)
+
+	return append(append(rec, loc...), eocd...)
+}
+
+func TestIsZip64(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    bool
+		entries int
+	}{
+		{"zip", makeTestZip(t, 10), false, 10},
+		{"zip64", makeTestZip(t, 70000), true, 70000},
+		{"zip64 record in the tail", makeZip64Tail(0), true, 0},
+		{"zip64 record before the tail", makeZip64Tail(zipTailSize), true, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, tt.data, nil)
+			ra := newTestReaderAt(t, ts.URL, nil)
+			got, err := IsZip64(ra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("IsZip64 = %v; want %v", got, tt.want)
+			}
+			entries, err := ListZipEntries(ra)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != tt.entries {
+				t.Errorf("ListZipEntries returned %d entries; want %d", len(entries), tt.entries)
+			}
+		})
+	}
+}
+
+func TestIsZip64NotZip(t *testing.T) {
+	ts := newTestServer(t, makeTestData(1000), nil)
+	ra := newTestReaderAt(t, ts.URL, nil)
+	if _, err := IsZip64(ra); err != ErrNotZip {
+		t.Errorf("IsZip64 error = %v; want %v", err, ErrNotZip)
+	}
+}
+
+// makeTestZipFiles returns a zip archive with n stored members of size
+// bytes each.
+func makeTestZipFiles(t *testing.T, n, size int) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	content := makeTestData(size)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("directory/subdirectory/member-%04d.txt", i)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(content)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestNewForZipRequests(t *testing.T) {
+	// the central directory is larger than the prefetched tail
+	data := makeTestZipFiles(t, 3000, 10)
+	tests := []struct {
+		name         string
+		newFunc      func(*http.Client, *http.Request, Store, ...Option) (*HTTPReaderAt, error)
+		opts         []Option
+		wantRequests int
+	}{
+		{"NewForZip", NewForZip, nil, 3},
+		{"NewForZip unlimited cache", NewForZip, []Option{WithBlockCache(64*1024, 0)}, 3},
+		{"New", New, nil, 0},
+	}
+	requests := make(map[string]int)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t, data, nil)
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			ra, err := tt.newFunc(nil, req, nil, tt.opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ra.Close()
+			zr, err := zip.NewReader(ra, ra.Size())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(zr.File) != 3000 {
+				t.Errorf("%d members; want 3000", len(zr.File))
+			}
+			requests[tt.name] = ts.Requests()
+			if tt.wantRequests != 0 && ts.Requests() > tt.wantRequests {
+				t.Errorf("requests = %d; want at most %d", ts.Requests(), tt.wantRequests)
+			}
+		})
+	}
+	t.Logf("requests: %v", requests)
+	if requests["New"] <= requests["NewForZip"] {
+		t.Errorf("New made %d requests, NewForZip %d; want fewer with NewForZip",
+			requests["New"], requests["NewForZip"])
+	}
+}